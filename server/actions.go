@@ -0,0 +1,180 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	// actionSecretKey is the KV key of the per-install secret signing button contexts
+	actionSecretKey = "action_signing_secret"
+
+	// actionSignatureKey is the context key carrying the signature
+	actionSignatureKey = "signature"
+)
+
+// ensureActionSecret loads the secret used to sign button contexts, creating
+// it on first activation. The secret is set atomically so every node of a
+// cluster ends up with the same one.
+func (p *Plugin) ensureActionSecret() error {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return errors.Wrap(err, "failed to generate action signing secret")
+	}
+	if _, appErr := p.API.KVSetWithOptions(actionSecretKey, secret, model.PluginKVSetOptions{Atomic: true, OldValue: nil}); appErr != nil {
+		return errors.Wrap(appErr, "failed to store action signing secret")
+	}
+
+	stored, appErr := p.API.KVGet(actionSecretKey)
+	if appErr != nil {
+		return errors.Wrap(appErr, "failed to load action signing secret")
+	}
+	if len(stored) == 0 {
+		return errors.New("action signing secret is missing")
+	}
+	p.actionSecret = stored
+	return nil
+}
+
+// signActionContext returns the signature of the context without the
+// signature itself. The entries are signed as JSON, which sorts the keys and
+// escapes the values, so no two contexts are signed as the same bytes. An
+// empty string is returned if the context cannot be encoded.
+func (p *Plugin) signActionContext(context map[string]interface{}) string {
+	signed := make(map[string]interface{}, len(context))
+	for key, value := range context {
+		if key != actionSignatureKey {
+			signed[key] = value
+		}
+	}
+
+	data, err := json.Marshal(signed)
+	if err != nil {
+		return ""
+	}
+
+	mac := hmac.New(sha256.New, p.actionSecret)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ticketActionContext builds the signed integration context identifying the
+// ticket for a button, with optional extra key/value pairs
+func (p *Plugin) ticketActionContext(ticket *Ticket, extra ...string) map[string]interface{} {
+	context := map[string]interface{}{
+		"ticket":     ticket.Key(),
+		"ticket_id":  ticket.ID,
+		"channel_id": ticket.ChannelID,
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		context[extra[i]] = extra[i+1]
+	}
+	context[actionSignatureKey] = p.signActionContext(context)
+	return context
+}
+
+// verifyActionContext reports whether the context carries a valid signature
+func (p *Plugin) verifyActionContext(context map[string]interface{}) bool {
+	signature, _ := context[actionSignatureKey].(string)
+	if signature == "" || len(p.actionSecret) == 0 {
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(p.signActionContext(context)))
+}
+
+// authenticatedUser reports whether the user named in a request body is the
+// user the server authenticated the request for
+func authenticatedUser(r *http.Request, userID string) bool {
+	headerUserID := r.Header.Get("Mattermost-User-ID")
+	return headerUserID != "" && headerUserID == userID
+}
+
+// checkTicketAccess returns why the user may not act on the ticket, or an
+// empty string when the user is a member of the ticket's channel
+func (p *Plugin) checkTicketAccess(ticket *Ticket, userID string) string {
+	if _, appErr := p.API.GetChannelMember(ticket.ChannelID, userID); appErr != nil {
+		return fmt.Sprintf("You must be a member of the channel of %s to update it.", ticket.Key())
+	}
+	return ""
+}
+
+// decodeActionRequest decodes a button click and verifies that it was sent by
+// the authenticated user, carries a context signed by this plugin, belongs to
+// the ticket's own post and channel, and that the user is a member of that
+// channel. When the request is rejected the response is written and nil is
+// returned.
+func (p *Plugin) decodeActionRequest(w http.ResponseWriter, r *http.Request) (*model.PostActionIntegrationRequest, *Ticket) {
+	var req model.PostActionIntegrationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		p.API.LogError("Failed to decode action request", "error", err.Error(), "path", r.URL.Path)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return nil, nil
+	}
+
+	if !authenticatedUser(r, req.UserId) {
+		p.API.LogWarn("Rejected action request for another user", "path", r.URL.Path, "user_id", req.UserId)
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return nil, nil
+	}
+
+	if !p.verifyActionContext(req.Context) {
+		p.refreshStaleButtons(w, &req)
+		return nil, nil
+	}
+
+	ticketID, _ := req.Context["ticket_id"].(string)
+	ticket, err := p.getTicket(ticketID)
+	if err != nil {
+		p.API.LogError("Failed to get ticket for action", "error", err.Error(), "path", r.URL.Path)
+		http.Error(w, "Failed to get ticket", http.StatusInternalServerError)
+		return nil, nil
+	}
+	if ticket == nil {
+		p.writeIntegrationResponse(w, "This ticket no longer exists.")
+		return nil, nil
+	}
+
+	post, appErr := p.API.GetPost(req.PostId)
+	channelID, _ := req.Context["channel_id"].(string)
+	if appErr != nil || post.Id != ticket.PostID || post.ChannelId != ticket.ChannelID || channelID != ticket.ChannelID || req.ChannelId != ticket.ChannelID {
+		p.API.LogWarn("Rejected action request for a post that does not belong to the ticket", "path", r.URL.Path, "ticket", ticket.Key(), "post_id", req.PostId, "user_id", req.UserId)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return nil, nil
+	}
+
+	if reason := p.checkTicketAccess(ticket, req.UserId); reason != "" {
+		p.writeIntegrationResponse(w, reason)
+		return nil, nil
+	}
+
+	return &req, ticket
+}
+
+// refreshStaleButtons answers a click on a button without a valid signature.
+// Ticket posts rendered before buttons were signed, including legacy ticket
+// posts without a record, are re-rendered so the user can click again;
+// anything else is refused. The post ID is filled in
+// by the server, so it can be trusted to find the ticket.
+func (p *Plugin) refreshStaleButtons(w http.ResponseWriter, req *model.PostActionIntegrationRequest) {
+	ticket, err := p.getOrImportTicketByPostID(req.PostId)
+	if err != nil || ticket == nil {
+		p.API.LogWarn("Rejected action request with an invalid signature", "post_id", req.PostId, "user_id", req.UserId)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if err := p.updateTicketPost(ticket); err != nil {
+		p.API.LogError("Failed to refresh ticket buttons", "error", err.Error(), "ticket", ticket.Key())
+		http.Error(w, "Failed to update ticket", http.StatusInternalServerError)
+		return
+	}
+	p.writeIntegrationResponse(w, fmt.Sprintf("The buttons of %s were out of date and have been refreshed. Please try again.", ticket.Key()))
+}
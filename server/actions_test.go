@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// allowLogs lets the plugin log at any level with up to five key/value pairs
+func allowLogs(api *plugintest.API) {
+	for _, level := range []string{"LogDebug", "LogInfo", "LogWarn", "LogError"} {
+		for n := 1; n <= 11; n += 2 {
+			args := make([]interface{}, n)
+			for i := range args {
+				args[i] = mock.Anything
+			}
+			api.On(level, args...).Maybe()
+		}
+	}
+}
+
+// newTestPlugin returns a plugin wired to a mocked API, with a signing secret
+// and the given settings applied
+func newTestPlugin(t *testing.T, settings *configuration) (*Plugin, *plugintest.API) {
+	t.Helper()
+
+	api := &plugintest.API{}
+	allowLogs(api)
+	t.Cleanup(func() { api.AssertExpectations(t) })
+
+	p := &Plugin{}
+	p.SetAPI(api)
+	p.botUserID = "botuserid"
+	p.actionSecret = []byte("test-signing-secret")
+
+	if settings == nil {
+		settings = &configuration{}
+	}
+	require.NoError(t, settings.parse())
+	p.setConfiguration(settings)
+	return p, api
+}
+
+// testTicket returns an open ticket stored under its ID in the mocked KV store
+func testTicket(api *plugintest.API) *Ticket {
+	ticket := &Ticket{
+		ID:         "ticketid",
+		Prefix:     "TCK",
+		Number:     142,
+		PostID:     "ticketpostid",
+		ChannelID:  "ticketchannelid",
+		ReporterID: "reporterid",
+		Status:     TicketStatusOpen,
+	}
+	data, _ := json.Marshal(ticket)
+	api.On("KVGet", ticketKey(ticket.ID)).Return(data, nil).Maybe()
+	return ticket
+}
+
+func TestVerifyActionContext(t *testing.T) {
+	p, api := newTestPlugin(t, nil)
+	ticket := testTicket(api)
+
+	other := &Plugin{actionSecret: []byte("another-secret")}
+
+	for name, tc := range map[string]struct {
+		context func() map[string]interface{}
+		valid   bool
+	}{
+		"signed context": {
+			context: func() map[string]interface{} { return p.ticketActionContext(ticket) },
+			valid:   true,
+		},
+		"signed context with extras": {
+			context: func() map[string]interface{} { return p.ticketActionContext(ticket, "to", TicketStatusResolved) },
+			valid:   true,
+		},
+		"missing signature": {
+			context: func() map[string]interface{} {
+				context := p.ticketActionContext(ticket)
+				delete(context, actionSignatureKey)
+				return context
+			},
+		},
+		"empty signature": {
+			context: func() map[string]interface{} {
+				context := p.ticketActionContext(ticket)
+				context[actionSignatureKey] = ""
+				return context
+			},
+		},
+		"signature of another value": {
+			context: func() map[string]interface{} {
+				context := p.ticketActionContext(ticket)
+				context[actionSignatureKey] = 42
+				return context
+			},
+		},
+		"tampered ticket": {
+			context: func() map[string]interface{} {
+				context := p.ticketActionContext(ticket)
+				context["ticket_id"] = "otherticketid"
+				return context
+			},
+		},
+		"tampered channel": {
+			context: func() map[string]interface{} {
+				context := p.ticketActionContext(ticket)
+				context["channel_id"] = "otherchannelid"
+				return context
+			},
+		},
+		"tampered extra": {
+			context: func() map[string]interface{} {
+				context := p.ticketActionContext(ticket, "to", TicketStatusOpen)
+				context["to"] = TicketStatusResolved
+				return context
+			},
+		},
+		"value split into another key": {
+			context: func() map[string]interface{} {
+				context := p.ticketActionContext(ticket, "to", TicketStatusResolved+"\nzz=1")
+				context["to"] = TicketStatusResolved
+				context["zz"] = "1"
+				return context
+			},
+		},
+		"added key": {
+			context: func() map[string]interface{} {
+				context := p.ticketActionContext(ticket)
+				context["assignee_id"] = "attackerid"
+				return context
+			},
+		},
+		"signed with another secret": {
+			context: func() map[string]interface{} { return other.ticketActionContext(ticket) },
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.valid, p.verifyActionContext(tc.context()))
+		})
+	}
+
+	t.Run("no secret", func(t *testing.T) {
+		unsigned := &Plugin{}
+		assert.False(t, unsigned.verifyActionContext(unsigned.ticketActionContext(ticket)))
+	})
+}
+
+func TestDecodeActionRequest(t *testing.T) {
+	const (
+		userID       = "userid"
+		otherPostID  = "otherpostid"
+		otherChannel = "otherchannelid"
+		otherMessage = "not a ticket"
+	)
+
+	for name, tc := range map[string]struct {
+		headerUserID string
+		request      func(p *Plugin, ticket *Ticket) *model.PostActionIntegrationRequest
+		setup        func(api *plugintest.API, ticket *Ticket)
+		status       int
+		ephemeral    string
+		accepted     bool
+	}{
+		"valid click": {
+			headerUserID: userID,
+			setup: func(api *plugintest.API, ticket *Ticket) {
+				api.On("GetPost", ticket.PostID).Return(&model.Post{Id: ticket.PostID, ChannelId: ticket.ChannelID}, nil)
+				api.On("GetChannelMember", ticket.ChannelID, userID).Return(&model.ChannelMember{}, nil)
+			},
+			status:   http.StatusOK,
+			accepted: true,
+		},
+		"missing user header": {
+			status: http.StatusUnauthorized,
+		},
+		"header for another user": {
+			headerUserID: "otheruserid",
+			status:       http.StatusUnauthorized,
+		},
+		"missing signature on a copied button": {
+			headerUserID: userID,
+			request: func(p *Plugin, ticket *Ticket) *model.PostActionIntegrationRequest {
+				req := testActionRequest(p, ticket, userID)
+				delete(req.Context, actionSignatureKey)
+				req.PostId = otherPostID
+				return req
+			},
+			setup: func(api *plugintest.API, ticket *Ticket) {
+				expectNoTicketPost(api, otherPostID, otherMessage)
+			},
+			status: http.StatusForbidden,
+		},
+		"tampered context on a copied button": {
+			headerUserID: userID,
+			request: func(p *Plugin, ticket *Ticket) *model.PostActionIntegrationRequest {
+				req := testActionRequest(p, ticket, userID)
+				req.Context["ticket_id"] = "otherticketid"
+				req.PostId = otherPostID
+				return req
+			},
+			setup: func(api *plugintest.API, ticket *Ticket) {
+				expectNoTicketPost(api, otherPostID, otherMessage)
+			},
+			status: http.StatusForbidden,
+		},
+		"button copied onto another post": {
+			headerUserID: userID,
+			request: func(p *Plugin, ticket *Ticket) *model.PostActionIntegrationRequest {
+				req := testActionRequest(p, ticket, userID)
+				req.PostId = otherPostID
+				return req
+			},
+			setup: func(api *plugintest.API, ticket *Ticket) {
+				api.On("GetPost", otherPostID).Return(&model.Post{Id: otherPostID, ChannelId: ticket.ChannelID}, nil)
+			},
+			status: http.StatusForbidden,
+		},
+		"button copied into another channel": {
+			headerUserID: userID,
+			request: func(p *Plugin, ticket *Ticket) *model.PostActionIntegrationRequest {
+				req := testActionRequest(p, ticket, userID)
+				req.ChannelId = otherChannel
+				return req
+			},
+			setup: func(api *plugintest.API, ticket *Ticket) {
+				api.On("GetPost", ticket.PostID).Return(&model.Post{Id: ticket.PostID, ChannelId: ticket.ChannelID}, nil)
+			},
+			status: http.StatusForbidden,
+		},
+		"post in another channel than the ticket": {
+			headerUserID: userID,
+			setup: func(api *plugintest.API, ticket *Ticket) {
+				api.On("GetPost", ticket.PostID).Return(&model.Post{Id: ticket.PostID, ChannelId: otherChannel}, nil)
+			},
+			status: http.StatusForbidden,
+		},
+		"non-member": {
+			headerUserID: userID,
+			setup: func(api *plugintest.API, ticket *Ticket) {
+				api.On("GetPost", ticket.PostID).Return(&model.Post{Id: ticket.PostID, ChannelId: ticket.ChannelID}, nil)
+				api.On("GetChannelMember", ticket.ChannelID, userID).Return(nil, model.NewAppError("GetChannelMember", "not_found", nil, "", http.StatusNotFound))
+			},
+			status:    http.StatusOK,
+			ephemeral: "You must be a member of the channel of TCK-142 to update it.",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			p, api := newTestPlugin(t, nil)
+			ticket := testTicket(api)
+			if tc.setup != nil {
+				tc.setup(api, ticket)
+			}
+
+			req := testActionRequest(p, ticket, userID)
+			if tc.request != nil {
+				req = tc.request(p, ticket)
+			}
+			body, err := json.Marshal(req)
+			require.NoError(t, err)
+
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/runresolve", bytes.NewReader(body))
+			if tc.headerUserID != "" {
+				r.Header.Set("Mattermost-User-ID", tc.headerUserID)
+			}
+			w := httptest.NewRecorder()
+
+			decoded, decodedTicket := p.decodeActionRequest(w, r)
+			assert.Equal(t, tc.status, w.Code)
+			if !tc.accepted {
+				assert.Nil(t, decoded)
+				assert.Nil(t, decodedTicket)
+			} else {
+				require.NotNil(t, decodedTicket)
+				assert.Equal(t, ticket.ID, decodedTicket.ID)
+				assert.Equal(t, userID, decoded.UserId)
+			}
+
+			if tc.ephemeral != "" {
+				var resp model.PostActionIntegrationResponse
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+				assert.Equal(t, tc.ephemeral, resp.EphemeralText)
+			}
+		})
+	}
+}
+
+// testActionRequest returns a click on the ticket's own post with a signed context
+func testActionRequest(p *Plugin, ticket *Ticket, userID string) *model.PostActionIntegrationRequest {
+	return &model.PostActionIntegrationRequest{
+		UserId:    userID,
+		PostId:    ticket.PostID,
+		ChannelId: ticket.ChannelID,
+		Context:   p.ticketActionContext(ticket),
+	}
+}
+
+// expectNoTicketPost mocks the lookups made for a click with an invalid
+// signature on a post that is neither indexed nor a legacy ticket post
+func expectNoTicketPost(api *plugintest.API, postID, message string) {
+	api.On("KVGet", ticketPostKey(postID)).Return(nil, nil)
+	api.On("GetPost", postID).Return(&model.Post{Id: postID, Message: message}, nil)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"slices"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+)
+
+// MessageHasBeenPosted records replies in a ticket thread on the ticket:
+// the time of the last activity, the number of replies, the first response
+// by someone other than the reporter and who took part. Posts by the
+// plugin's bot, other bots, webhooks and system messages are not counted.
+func (p *Plugin) MessageHasBeenPosted(c *plugin.Context, post *model.Post) {
+	if post.RootId == "" || !countsAsTicketActivity(post, p.botUserID) {
+		return
+	}
+
+	ticket, err := p.getTicketByPostID(post.RootId)
+	if err != nil {
+		p.API.LogError("Failed to get ticket for thread reply", "error", err.Error(), "post_id", post.Id)
+		return
+	}
+	if ticket == nil {
+		return
+	}
+
+	if _, err := p.modifyTicket(ticket.ID, func(latest *Ticket) bool {
+		recordThreadReply(latest, post)
+		return true
+	}); err != nil {
+		p.API.LogError("Failed to record ticket activity", "error", err.Error(), "ticket", ticket.Key())
+	}
+}
+
+// countsAsTicketActivity reports whether the post was written by a person
+func countsAsTicketActivity(post *model.Post, botUserID string) bool {
+	if post.UserId == "" || post.UserId == botUserID || post.IsSystemMessage() {
+		return false
+	}
+	return post.GetProp("from_bot") != "true" && post.GetProp("from_webhook") != "true"
+}
+
+// recordThreadReply updates the activity fields of the ticket for the reply
+func recordThreadReply(ticket *Ticket, post *model.Post) {
+	ticket.ReplyCount++
+	if post.CreateAt > ticket.LastActivityAt {
+		ticket.LastActivityAt = post.CreateAt
+	}
+	if ticket.FirstResponseAt == 0 && post.UserId != ticket.ReporterID {
+		ticket.FirstResponseAt = post.CreateAt
+		ticket.FirstResponderID = post.UserId
+	}
+	if !slices.Contains(ticket.Participants, post.UserId) {
+		ticket.Participants = append(ticket.Participants, post.UserId)
+	}
+}
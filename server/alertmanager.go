@@ -0,0 +1,365 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/pluginapi/cluster"
+	"github.com/pkg/errors"
+)
+
+// alertmanagerPathSuffix marks the Alertmanager flavour of an incoming webhook
+const alertmanagerPathSuffix = "/alertmanager"
+
+// alertTicketKeyPrefix indexes tickets by incoming hook and alert fingerprint
+const alertTicketKeyPrefix = "alertticket_"
+
+// alertTicketIndex is stored under the alert ticket key. StartsAt identifies
+// the firing the ticket last saw and Resolved whether its end was noted, so
+// resends of the same notification are ignored. Indexes written by earlier
+// versions hold only the ticket ID.
+type alertTicketIndex struct {
+	TicketID string `json:"ticket_id"`
+	StartsAt string `json:"starts_at,omitempty"`
+	Resolved bool   `json:"resolved,omitempty"`
+}
+
+// Alertmanager alert statuses
+const (
+	alertStatusFiring   = "firing"
+	alertStatusResolved = "resolved"
+)
+
+// AlertmanagerMapping describes how alert labels map to ticket fields. Empty
+// label names fall back to "team", "project", "environment" and "severity".
+// Type optionally sets the ticket type of alert tickets.
+type AlertmanagerMapping struct {
+	TeamLabel          string            `json:"team_label"`
+	ProjectLabel       string            `json:"project_label"`
+	EnvironmentLabel   string            `json:"environment_label"`
+	SeverityLabel      string            `json:"severity_label"`
+	DefaultTeam        string            `json:"default_team"`
+	DefaultProject     string            `json:"default_project"`
+	DefaultEnvironment string            `json:"default_environment"`
+	SeverityPriorities map[string]string `json:"severity_priorities"`
+	Type               string            `json:"type"`
+}
+
+// defaultSeverityPriorities maps common Alertmanager severities to ticket priorities
+var defaultSeverityPriorities = map[string]string{
+	"critical": "urgent",
+	"page":     "urgent",
+	"error":    "important",
+	"warning":  "important",
+}
+
+// alertmanagerPayload is the webhook body sent by Prometheus Alertmanager
+type alertmanagerPayload struct {
+	Version     string              `json:"version"`
+	GroupKey    string              `json:"groupKey"`
+	Status      string              `json:"status"`
+	Receiver    string              `json:"receiver"`
+	ExternalURL string              `json:"externalURL"`
+	Alerts      []alertmanagerAlert `json:"alerts"`
+}
+
+// alertmanagerAlert is a single alert of an Alertmanager notification
+type alertmanagerAlert struct {
+	Status       string            `json:"status"`
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     string            `json:"startsAt"`
+	EndsAt       string            `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL"`
+	Fingerprint  string            `json:"fingerprint"`
+}
+
+// alertmanagerResponse summarises what was done with a notification
+type alertmanagerResponse struct {
+	Created  []string `json:"created"`
+	Updated  []string `json:"updated"`
+	Resolved []string `json:"resolved"`
+	Errors   []string `json:"errors,omitempty"`
+}
+
+func alertTicketKey(hookID, fingerprint string) string {
+	return alertTicketKeyPrefix + hookID + "_" + fingerprint
+}
+
+// handleAlertmanagerWebhook ingests an Alertmanager notification:
+//
+//	POST /api/v1/incoming/{hookID}/alertmanager
+//	Authorization: Bearer <token>
+//
+// One ticket is opened per alert fingerprint. New firings are posted to the
+// ticket thread and a resolved notification resolves the ticket.
+func (p *Plugin) handleAlertmanagerWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		p.writeAPIError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	hookID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, incomingPathPrefix), alertmanagerPathSuffix)
+	hook := p.authenticateIncomingWebhook(w, r, hookID)
+	if hook == nil {
+		return
+	}
+
+	var payload alertmanagerPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		p.writeAPIError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	// Alertmanager may deliver notifications for the same alert concurrently,
+	// so alerts of a hook are processed one at a time across the cluster
+	mutex, err := cluster.NewMutex(p.API, "alertmanager_"+hook.ID)
+	if err != nil {
+		p.API.LogError("Failed to create alertmanager mutex", "error", err.Error())
+		p.writeAPIError(w, http.StatusInternalServerError, "Failed to process alerts")
+		return
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	resp := &alertmanagerResponse{Created: []string{}, Updated: []string{}, Resolved: []string{}}
+	for _, alert := range payload.Alerts {
+		if err := p.processAlert(hook, &alert, resp); err != nil {
+			p.API.LogError("Failed to process alert", "error", err.Error(), "hook_id", hook.ID, "fingerprint", alert.Fingerprint)
+			resp.Errors = append(resp.Errors, fmt.Sprintf("%s: %s", alert.Fingerprint, err.Error()))
+		}
+	}
+
+	status := http.StatusOK
+	if len(resp.Errors) > 0 {
+		// Alertmanager retries failed notifications, which is safe because
+		// alerts are matched by fingerprint
+		status = http.StatusInternalServerError
+	}
+	p.writeJSON(w, status, resp)
+}
+
+// processAlert opens, updates or resolves the ticket for a single alert
+func (p *Plugin) processAlert(hook *IncomingWebhook, alert *alertmanagerAlert, resp *alertmanagerResponse) error {
+	if alert.Fingerprint == "" {
+		return errors.New("alert has no fingerprint")
+	}
+
+	index, err := p.getAlertTicketIndex(hook.ID, alert.Fingerprint)
+	if err != nil {
+		return err
+	}
+	var ticket *Ticket
+	if index != nil {
+		if ticket, err = p.getTicket(index.TicketID); err != nil {
+			return err
+		}
+	}
+
+	if alert.Status != alertStatusFiring && alert.Status != alertStatusResolved {
+		return errors.Errorf("unknown alert status %q", alert.Status)
+	}
+	if ticket == nil {
+		if alert.Status == alertStatusResolved {
+			return nil
+		}
+		return p.openAlertTicket(hook, alert, resp)
+	}
+
+	workflow := p.getWorkflow(ticket.Type)
+	if alert.Status == alertStatusResolved {
+		// A resend of a resolution that was already handled must not resolve
+		// a ticket someone reopened since
+		if workflow.IsDone(ticket.Status) || (index.Resolved && index.StartsAt == alert.StartsAt) {
+			return nil
+		}
+		resolved := &alertTicketIndex{TicketID: ticket.ID, StartsAt: alert.StartsAt, Resolved: true}
+		if !workflow.CanTransition(ticket.Status, TicketStatusResolved) || p.transitionDisabled(workflow, ticket.Status, TicketStatusResolved) != "" {
+			// Leave the status to the humans but let them know the alert cleared
+			if err := p.postTicketReply(ticket, "✅ Alert resolved: "+alertTitle(alert)); err != nil {
+				return err
+			}
+			return p.saveAlertTicketIndex(hook.ID, alert.Fingerprint, resolved)
+		}
+		if err := p.transitionTicket(ticket, TicketStatusResolved, p.botUserID, SourceAlertmanager); err != nil {
+			return err
+		}
+		resp.Resolved = append(resp.Resolved, ticket.Key())
+		return p.saveAlertTicketIndex(hook.ID, alert.Fingerprint, resolved)
+	}
+
+	// Alertmanager resends every alert of a group on each group and repeat
+	// interval; only a new firing has a different start time
+	if index.StartsAt != "" && index.StartsAt == alert.StartsAt {
+		return nil
+	}
+
+	if workflow.IsDone(ticket.Status) {
+		if !workflow.CanTransition(ticket.Status, TicketStatusOpen) || p.transitionDisabled(workflow, ticket.Status, TicketStatusOpen) != "" {
+			// The old ticket cannot be reopened, so the alert gets a new one
+			return p.openAlertTicket(hook, alert, resp)
+		}
+		if err := p.transitionTicket(ticket, TicketStatusOpen, p.botUserID, SourceAlertmanager); err != nil {
+			return err
+		}
+	}
+	if err := p.postTicketReply(ticket, "🔥 Alert firing again: "+alertTitle(alert)+"\n\n"+alertDetails(alert)); err != nil {
+		return err
+	}
+	if err := p.saveAlertTicketIndex(hook.ID, alert.Fingerprint, &alertTicketIndex{TicketID: ticket.ID, StartsAt: alert.StartsAt}); err != nil {
+		return err
+	}
+	resp.Updated = append(resp.Updated, ticket.Key())
+	return nil
+}
+
+// openAlertTicket creates a ticket for the alert and indexes it by fingerprint
+func (p *Plugin) openAlertTicket(hook *IncomingWebhook, alert *alertmanagerAlert, resp *alertmanagerResponse) error {
+	if reason := p.ticketCreationDisabled(hook.ChannelID); reason != "" {
+		return errors.New(reason)
+	}
+
+	ticketData := p.alertTicketData(hook, alert)
+	if reason := p.validateTicketFields(ticketData, hook.ChannelID); reason != "" {
+		return errors.New(reason)
+	}
+
+	ticket, err := p.createTicket(ticketData, hook.ChannelID, p.botUserID, SourceAlertmanager)
+	if err != nil {
+		return err
+	}
+
+	if err := p.saveAlertTicketIndex(hook.ID, alert.Fingerprint, &alertTicketIndex{TicketID: ticket.ID, StartsAt: alert.StartsAt}); err != nil {
+		return err
+	}
+
+	resp.Created = append(resp.Created, ticket.Key())
+	return nil
+}
+
+// getAlertTicketIndex returns the index of the ticket opened for the alert
+// fingerprint, or nil if there is none
+func (p *Plugin) getAlertTicketIndex(hookID, fingerprint string) (*alertTicketIndex, error) {
+	data, appErr := p.API.KVGet(alertTicketKey(hookID, fingerprint))
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to get alert ticket index")
+	}
+	if data == nil {
+		return nil, nil
+	}
+
+	var index alertTicketIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		// Earlier versions stored the bare ticket ID
+		return &alertTicketIndex{TicketID: string(data)}, nil
+	}
+	return &index, nil
+}
+
+// saveAlertTicketIndex stores the ticket and firing of the alert fingerprint
+func (p *Plugin) saveAlertTicketIndex(hookID, fingerprint string, index *alertTicketIndex) error {
+	data, err := json.Marshal(index)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal alert ticket index")
+	}
+	if appErr := p.API.KVSet(alertTicketKey(hookID, fingerprint), data); appErr != nil {
+		return errors.Wrap(appErr, "failed to save alert ticket index")
+	}
+	return nil
+}
+
+// alertTicketData maps the alert labels and annotations to ticket fields.
+// Label values that are not configured options fall back to the hook defaults.
+func (p *Plugin) alertTicketData(hook *IncomingWebhook, alert *alertmanagerAlert) TicketDialog {
+	mapping := hook.Alertmanager
+	if mapping == nil {
+		mapping = &AlertmanagerMapping{}
+	}
+
+	pick := func(label, fallbackLabel, fallback string, options []*model.PostActionOptions) string {
+		if label == "" {
+			label = fallbackLabel
+		}
+		if value := alert.Labels[label]; hasOption(options, value) {
+			return value
+		}
+		return fallback
+	}
+
+	severities := mapping.SeverityPriorities
+	if len(severities) == 0 {
+		severities = defaultSeverityPriorities
+	}
+	severityLabel := mapping.SeverityLabel
+	if severityLabel == "" {
+		severityLabel = "severity"
+	}
+	priority := severities[strings.ToLower(alert.Labels[severityLabel])]
+	if !hasOption(priorityOptions, priority) {
+		priority = "standard"
+	}
+
+	// Custom fields are filled from labels of the same name
+	var fields map[string]string
+	for _, field := range p.getCustomFields(mapping.Type) {
+		if value := alert.Labels[field.Name]; value != "" {
+			if fields == nil {
+				fields = make(map[string]string)
+			}
+			fields[field.Name] = value
+		}
+	}
+
+	return TicketDialog{
+		TeamName:    pick(mapping.TeamLabel, "team", mapping.DefaultTeam, p.getTeamOptions(hook.ChannelID)),
+		ProjectName: pick(mapping.ProjectLabel, "project", mapping.DefaultProject, p.getProjectOptions(hook.ChannelID)),
+		Environment: pick(mapping.EnvironmentLabel, "environment", mapping.DefaultEnvironment, environmentOptions),
+		Priority:    priority,
+		Summary:     alertTitle(alert),
+		Description: alertDetails(alert),
+		Type:        mapping.Type,
+		Fields:      fields,
+	}
+}
+
+// alertTitle returns the summary annotation of the alert, or its name
+func alertTitle(alert *alertmanagerAlert) string {
+	if summary := alert.Annotations["summary"]; summary != "" {
+		return summary
+	}
+	if name := alert.Labels["alertname"]; name != "" {
+		return name
+	}
+	return alert.Fingerprint
+}
+
+// alertDetails renders the alert description, labels and source link as Markdown
+func alertDetails(alert *alertmanagerAlert) string {
+	var b strings.Builder
+	if description := alert.Annotations["description"]; description != "" {
+		b.WriteString(description + "\n\n")
+	}
+
+	names := make([]string, 0, len(alert.Labels))
+	for name := range alert.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	b.WriteString("**Labels:**\n")
+	for _, name := range names {
+		fmt.Fprintf(&b, "• `%s`: `%s`\n", name, alert.Labels[name])
+	}
+
+	if alert.StartsAt != "" {
+		fmt.Fprintf(&b, "\n**Started:** %s", alert.StartsAt)
+	}
+	if alert.GeneratorURL != "" {
+		fmt.Fprintf(&b, "\n**Source:** %s", alert.GeneratorURL)
+	}
+	return b.String()
+}
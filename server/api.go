@@ -0,0 +1,294 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// apiTicketsPath is the root of the ticket REST API
+const apiTicketsPath = "/api/v1/tickets"
+
+// maxAPIPerPage caps the page size of the ticket list endpoint
+const maxAPIPerPage = 200
+
+// ticketListResponse is returned by the ticket list endpoint
+type ticketListResponse struct {
+	Tickets []*Ticket `json:"tickets"`
+	Total   int       `json:"total"`
+	Page    int       `json:"page"`
+	PerPage int       `json:"per_page"`
+}
+
+// createTicketRequest is the body accepted by the create ticket endpoint
+type createTicketRequest struct {
+	ChannelID string `json:"channel_id"`
+	TicketDialog
+}
+
+// transitionRequest is the body accepted by the transition endpoint
+type transitionRequest struct {
+	Status string `json:"status"`
+}
+
+// handleTicketsAPI routes the authenticated ticket REST API:
+//
+//	GET   /api/v1/tickets                     list tickets
+//	POST  /api/v1/tickets                     create a ticket
+//	GET   /api/v1/tickets/{ticket}            get a ticket
+//	PATCH /api/v1/tickets/{ticket}            update ticket fields
+//	POST  /api/v1/tickets/{ticket}/transition change the ticket status
+func (p *Plugin) handleTicketsAPI(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+	if userID == "" {
+		p.writeAPIError(w, http.StatusUnauthorized, "Not authorized")
+		return
+	}
+
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, apiTicketsPath), "/")
+	parts := strings.Split(rest, "/")
+
+	switch {
+	case rest == "" && r.Method == http.MethodGet:
+		p.handleAPIListTickets(w, r, userID)
+	case rest == "" && r.Method == http.MethodPost:
+		p.handleAPICreateTicket(w, r, userID)
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		p.handleAPIGetTicket(w, userID, parts[0])
+	case len(parts) == 1 && r.Method == http.MethodPatch:
+		p.handleAPIUpdateTicket(w, r, userID, parts[0])
+	case len(parts) == 2 && parts[1] == "transition" && r.Method == http.MethodPost:
+		p.handleAPITransitionTicket(w, r, userID, parts[0])
+	case rest == "" || len(parts) == 1 || (len(parts) == 2 && parts[1] == "transition"):
+		p.writeAPIError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	default:
+		p.writeAPIError(w, http.StatusNotFound, "Not found")
+	}
+}
+
+// handleAPIListTickets returns the tickets matching the query filters in
+// channels the user can read
+func (p *Plugin) handleAPIListTickets(w http.ResponseWriter, r *http.Request, userID string) {
+	query := r.URL.Query()
+
+	page, err := strconv.Atoi(query.Get("page"))
+	if err != nil || page < 0 {
+		page = 0
+	}
+	perPage, err := strconv.Atoi(query.Get("per_page"))
+	if err != nil || perPage <= 0 {
+		perPage = 50
+	}
+	if perPage > maxAPIPerPage {
+		perPage = maxAPIPerPage
+	}
+
+	filter := &TicketFilter{
+		ChannelID:   query.Get("channel_id"),
+		Status:      query.Get("status"),
+		TeamName:    query.Get("team"),
+		ProjectName: query.Get("project"),
+		Environment: query.Get("environment"),
+		Priority:    query.Get("priority"),
+		Type:        query.Get("type"),
+		AssigneeID:  query.Get("assignee_id"),
+		ReporterID:  query.Get("reporter_id"),
+	}
+
+	if filter.ChannelID != "" && !p.API.HasPermissionToChannel(userID, filter.ChannelID, model.PermissionReadChannel) {
+		p.writeAPIError(w, http.StatusForbidden, "You do not have access to this channel")
+		return
+	}
+
+	readable := make(map[string]bool)
+	var matches []*Ticket
+	err = p.forEachTicket(func(ticket *Ticket) {
+		if !filter.Matches(ticket, p.getWorkflow(ticket.Type)) {
+			return
+		}
+		allowed, checked := readable[ticket.ChannelID]
+		if !checked {
+			allowed = p.API.HasPermissionToChannel(userID, ticket.ChannelID, model.PermissionReadChannel)
+			readable[ticket.ChannelID] = allowed
+		}
+		if allowed {
+			matches = append(matches, ticket)
+		}
+	})
+	if err != nil {
+		p.API.LogError("Failed to list tickets", "error", err.Error())
+		p.writeAPIError(w, http.StatusInternalServerError, "Failed to list tickets")
+		return
+	}
+
+	sortTicketsNewestFirst(matches)
+	resp := &ticketListResponse{Tickets: []*Ticket{}, Total: len(matches), Page: page, PerPage: perPage}
+	if start := page * perPage; start < len(matches) {
+		end := start + perPage
+		if end > len(matches) {
+			end = len(matches)
+		}
+		resp.Tickets = matches[start:end]
+	}
+
+	p.writeJSON(w, http.StatusOK, resp)
+}
+
+// handleAPIGetTicket returns a single ticket
+func (p *Plugin) handleAPIGetTicket(w http.ResponseWriter, userID, ref string) {
+	ticket := p.getAPITicket(w, userID, ref)
+	if ticket == nil {
+		return
+	}
+
+	p.writeJSON(w, http.StatusOK, ticket)
+}
+
+// handleAPICreateTicket creates a ticket on behalf of the user
+func (p *Plugin) handleAPICreateTicket(w http.ResponseWriter, r *http.Request, userID string) {
+	var req createTicketRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		p.writeAPIError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if req.ChannelID == "" {
+		p.writeAPIError(w, http.StatusBadRequest, "channel_id is required")
+		return
+	}
+	if !p.API.HasPermissionToChannel(userID, req.ChannelID, model.PermissionCreatePost) {
+		p.writeAPIError(w, http.StatusForbidden, "You cannot post in this channel")
+		return
+	}
+	if !p.validateChannel(req.ChannelID) {
+		p.writeAPIError(w, http.StatusBadRequest, "Tickets cannot be created in this channel")
+		return
+	}
+	if reason := p.ticketCreationDisabled(req.ChannelID); reason != "" {
+		p.writeAPIError(w, http.StatusForbidden, reason)
+		return
+	}
+	p.applyTicketDefaults(&req.TicketDialog, req.ChannelID)
+	if reason := p.validateTicketFields(req.TicketDialog, req.ChannelID); reason != "" {
+		p.writeAPIError(w, http.StatusBadRequest, reason)
+		return
+	}
+
+	ticket, err := p.createTicket(req.TicketDialog, req.ChannelID, userID, SourceAPI)
+	if err != nil {
+		p.writeAPIError(w, http.StatusInternalServerError, "Failed to create ticket")
+		return
+	}
+
+	p.writeJSON(w, http.StatusCreated, ticket)
+}
+
+// handleAPIUpdateTicket changes the fields set in the request body
+func (p *Plugin) handleAPIUpdateTicket(w http.ResponseWriter, r *http.Request, userID, ref string) {
+	ticket := p.getAPITicket(w, userID, ref)
+	if ticket == nil {
+		return
+	}
+	if reason := p.checkTicketAccess(ticket, userID); reason != "" {
+		p.writeAPIError(w, http.StatusForbidden, reason)
+		return
+	}
+
+	var patch TicketPatch
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		p.writeAPIError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if reason := p.validateTicketPatch(ticket, &patch); reason != "" {
+		p.writeAPIError(w, http.StatusBadRequest, reason)
+		return
+	}
+
+	if err := p.updateTicketFields(ticket, &patch, userID, SourceAPI); err != nil {
+		p.API.LogError("Failed to update ticket", "error", err.Error(), "ticket", ticket.Key())
+		p.writeAPIError(w, http.StatusInternalServerError, "Failed to update ticket")
+		return
+	}
+
+	p.writeJSON(w, http.StatusOK, ticket)
+}
+
+// handleAPITransitionTicket moves the ticket to the requested status
+func (p *Plugin) handleAPITransitionTicket(w http.ResponseWriter, r *http.Request, userID, ref string) {
+	ticket := p.getAPITicket(w, userID, ref)
+	if ticket == nil {
+		return
+	}
+	if reason := p.checkTicketAccess(ticket, userID); reason != "" {
+		p.writeAPIError(w, http.StatusForbidden, reason)
+		return
+	}
+
+	var req transitionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Status == "" {
+		p.writeAPIError(w, http.StatusBadRequest, "status is required")
+		return
+	}
+
+	workflow := p.getWorkflow(ticket.Type)
+	if workflow.Status(req.Status) == nil {
+		p.writeAPIError(w, http.StatusBadRequest, "Unknown status "+req.Status)
+		return
+	}
+	if !workflow.CanTransition(ticket.Status, req.Status) {
+		p.writeAPIError(w, http.StatusConflict, "Ticket cannot move from "+workflow.StatusName(ticket.Status)+" to "+workflow.StatusName(req.Status))
+		return
+	}
+	if reason := p.transitionDisabled(workflow, ticket.Status, req.Status); reason != "" {
+		p.writeAPIError(w, http.StatusForbidden, reason)
+		return
+	}
+	if reason := p.transitionDenied(ticket, workflow, ticket.Status, req.Status, userID); reason != "" {
+		p.writeAPIError(w, http.StatusForbidden, reason)
+		return
+	}
+
+	if err := p.transitionTicket(ticket, req.Status, userID, SourceAPI); err != nil {
+		p.API.LogError("Failed to transition ticket", "error", err.Error(), "ticket", ticket.Key())
+		p.writeAPIError(w, http.StatusInternalServerError, "Failed to update ticket")
+		return
+	}
+
+	p.writeJSON(w, http.StatusOK, ticket)
+}
+
+// getAPITicket loads the referenced ticket and checks the user can read its
+// channel. It writes the error response and returns nil otherwise.
+func (p *Plugin) getAPITicket(w http.ResponseWriter, userID, ref string) *Ticket {
+	ticket, err := p.findTicket(ref)
+	if err != nil {
+		p.API.LogError("Failed to get ticket", "error", err.Error(), "ticket", ref)
+		p.writeAPIError(w, http.StatusInternalServerError, "Failed to get ticket")
+		return nil
+	}
+
+	// Tickets in channels the user cannot read are reported as missing
+	if ticket == nil || !p.API.HasPermissionToChannel(userID, ticket.ChannelID, model.PermissionReadChannel) {
+		p.writeAPIError(w, http.StatusNotFound, "Ticket not found")
+		return nil
+	}
+
+	return ticket
+}
+
+// writeJSON writes v as a JSON response with the given status code
+func (p *Plugin) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		p.API.LogError("failed to encode response body", "error", err.Error())
+	}
+}
+
+// writeAPIError writes a JSON error response
+func (p *Plugin) writeAPIError(w http.ResponseWriter, status int, message string) {
+	p.writeJSON(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+// Where a ticket action came from
+const (
+	SourceCommand      = "command"
+	SourceButton       = "button"
+	SourceDialog       = "dialog"
+	SourceAPI          = "api"
+	SourceWebhook      = "webhook"
+	SourceAlertmanager = "alertmanager"
+	SourceReaction     = "reaction"
+	SourceLegacy       = "legacy"
+)
+
+const (
+	// auditKeyPrefix prefixes audit entries in the KV store. Keys continue
+	// with the ticket ID and the zero padded time so they sort chronologically.
+	auditKeyPrefix = "audit_"
+
+	// auditIndexKeyPrefix prefixes the per-ticket lists of audit entry keys,
+	// so reading a ticket's entries does not walk the whole KV store
+	auditIndexKeyPrefix = "auditindex_"
+
+	// auditIndexSinceKey stores when audit indexes were introduced. Tickets
+	// created since then have an index entry for every audit entry.
+	auditIndexSinceKey = "auditindexsince"
+
+	// auditCommandLimit is the number of most recent entries shown by /ticket audit
+	auditCommandLimit = 30
+)
+
+// AuditEntry records one action on a ticket. Entries are written once and
+// never changed.
+type AuditEntry struct {
+	ID        string                 `json:"id"`
+	TicketID  string                 `json:"ticket_id"`
+	TicketKey string                 `json:"ticket_key"`
+	ChannelID string                 `json:"channel_id"`
+	Action    string                 `json:"action"`
+	ActorID   string                 `json:"actor_id"`
+	Source    string                 `json:"source"`
+	Changes   map[string]FieldChange `json:"changes,omitempty"`
+	CreatedAt int64                  `json:"created_at"`
+}
+
+func auditTicketKeyPrefix(ticketID string) string {
+	return auditKeyPrefix + ticketID + "_"
+}
+
+func auditKey(entry *AuditEntry) string {
+	return fmt.Sprintf("%s%013d_%s", auditTicketKeyPrefix(entry.TicketID), entry.CreatedAt, entry.ID)
+}
+
+func auditIndexKey(ticketID string) string {
+	return auditIndexKeyPrefix + ticketID
+}
+
+// ensureAuditIndexSince records when audit indexes were introduced and loads it
+func (p *Plugin) ensureAuditIndexSince() error {
+	since, err := p.ensureActivationTime(auditIndexSinceKey)
+	if err != nil {
+		return err
+	}
+	p.auditIndexSince = since
+	return nil
+}
+
+// auditIndexScanPrefix returns the key prefix to build a missing audit index
+// of the ticket from. The index of a ticket created since audit indexes were
+// introduced is complete, so a missing one is empty and needs no scan.
+func (p *Plugin) auditIndexScanPrefix(ticket *Ticket) string {
+	if ticket.CreatedAt >= p.auditIndexSince {
+		return ""
+	}
+	return auditTicketKeyPrefix(ticket.ID)
+}
+
+// recordTicketEvent writes the audit entry of a ticket action and notifies
+// the webhook subscriptions
+func (p *Plugin) recordTicketEvent(event string, ticket *Ticket, actorID, source string, changes map[string]FieldChange) {
+	entry := &AuditEntry{
+		ID:        model.NewId(),
+		TicketID:  ticket.ID,
+		TicketKey: ticket.Key(),
+		ChannelID: ticket.ChannelID,
+		Action:    event,
+		ActorID:   actorID,
+		Source:    source,
+		Changes:   changes,
+		CreatedAt: model.GetMillis(),
+	}
+	if err := p.appendAuditEntry(ticket, entry); err != nil {
+		p.API.LogError("Failed to write audit entry", "error", err.Error(), "ticket", entry.TicketKey, "action", event)
+	}
+
+	p.emitTicketEvent(event, ticket, actorID, changes)
+}
+
+// appendAuditEntry stores the entry under a new key and adds it to the
+// ticket's audit index. The write only succeeds if the key does not exist yet,
+// so an entry is never overwritten.
+func (p *Plugin) appendAuditEntry(ticket *Ticket, entry *AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal audit entry")
+	}
+
+	saved, appErr := p.API.KVSetWithOptions(auditKey(entry), data, model.PluginKVSetOptions{Atomic: true, OldValue: nil})
+	if appErr != nil {
+		return errors.Wrap(appErr, "failed to save audit entry")
+	}
+	if !saved {
+		return errors.New("audit entry already exists")
+	}
+
+	prefix := auditTicketKeyPrefix(ticket.ID)
+	return p.addToKeyIndex(auditIndexKey(ticket.ID), p.auditIndexScanPrefix(ticket), strings.TrimPrefix(auditKey(entry), prefix))
+}
+
+// listAuditEntries loads the audit entries of the ticket through its audit
+// index, oldest first
+func (p *Plugin) listAuditEntries(ticket *Ticket) ([]*AuditEntry, error) {
+	ids, _, err := p.getKeyIndex(auditIndexKey(ticket.ID), p.auditIndexScanPrefix(ticket))
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := auditTicketKeyPrefix(ticket.ID)
+	entries := make([]*AuditEntry, 0, len(ids))
+	for _, id := range ids {
+		key := prefix + id
+		data, appErr := p.API.KVGet(key)
+		if appErr != nil {
+			return nil, errors.Wrap(appErr, "failed to get audit entry")
+		}
+		if data == nil {
+			continue
+		}
+
+		var entry AuditEntry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			p.API.LogError("Failed to unmarshal audit entry", "error", err.Error(), "key", key)
+			continue
+		}
+		entries = append(entries, &entry)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].CreatedAt < entries[j].CreatedAt
+	})
+	return entries, nil
+}
+
+// listAllAuditEntries loads the audit entries of every ticket, oldest first
+func (p *Plugin) listAllAuditEntries() ([]*AuditEntry, error) {
+	var entries []*AuditEntry
+	var listErr error
+	err := p.forEachTicket(func(ticket *Ticket) {
+		if listErr != nil {
+			return
+		}
+		ticketEntries, err := p.listAuditEntries(ticket)
+		if err != nil {
+			listErr = err
+			return
+		}
+		entries = append(entries, ticketEntries...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if listErr != nil {
+		return nil, listErr
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].CreatedAt < entries[j].CreatedAt
+	})
+	return entries, nil
+}
+
+// creationChanges describes a new ticket as changes from empty values, so the
+// audit log shows what the ticket was created with
+func creationChanges(ticket *Ticket) map[string]FieldChange {
+	changes := make(map[string]FieldChange)
+	add := func(field, value string) {
+		if value != "" {
+			changes[field] = FieldChange{To: value}
+		}
+	}
+	add("type", ticket.Type)
+	add("team_name", ticket.TeamName)
+	add("project_name", ticket.ProjectName)
+	add("environment", ticket.Environment)
+	add("priority", ticket.Priority)
+	add("summary", ticket.Summary)
+	add("description", ticket.Description)
+	add("status", ticket.Status)
+	for name, value := range ticket.Fields {
+		add("fields."+name, value)
+	}
+	return changes
+}
+
+// handleAuditCommand handles `/ticket audit <ticket>` and shows the most
+// recent audit entries of the ticket
+func (p *Plugin) handleAuditCommand(args *model.CommandArgs, params []string) *model.CommandResponse {
+	if len(params) < 1 {
+		return ephemeralResponse("Usage: /ticket audit <ticket>\nExample: /ticket audit TCK-142")
+	}
+
+	ticket, err := p.findTicket(params[0])
+	if err != nil {
+		return ephemeralResponse("Failed to find ticket: " + err.Error())
+	}
+	if ticket == nil || !p.API.HasPermissionToChannel(args.UserId, ticket.ChannelID, model.PermissionReadChannel) {
+		return ephemeralResponse(fmt.Sprintf("Ticket `%s` was not found. Please use a ticket number such as TCK-142.", params[0]))
+	}
+
+	entries, err := p.listAuditEntries(ticket)
+	if err != nil {
+		return ephemeralResponse("Failed to load the audit log: " + err.Error())
+	}
+	if len(entries) == 0 {
+		return ephemeralResponse(fmt.Sprintf("No audit entries were recorded for %s.", ticket.Key()))
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "**Audit log of %s**\n\n", ticket.Key())
+	if len(entries) > auditCommandLimit {
+		fmt.Fprintf(&b, "Showing the latest %d of %d entries.\n\n", auditCommandLimit, len(entries))
+		entries = entries[len(entries)-auditCommandLimit:]
+	}
+	b.WriteString("| Time | Action | By | Source | Changes |\n|---|---|---|---|---|\n")
+	for _, entry := range entries {
+		fmt.Fprintf(&b, "| %s | %s | @%s | %s | %s |\n",
+			time.UnixMilli(entry.CreatedAt).UTC().Format("2006-01-02 15:04 MST"),
+			strings.TrimPrefix(entry.Action, "ticket."),
+			p.getUsername(entry.ActorID),
+			entry.Source,
+			escapeTableCell(describeAuditChanges(entry.Changes)))
+	}
+
+	return ephemeralResponse(b.String())
+}
+
+// describeAuditChanges formats the changes of an entry on one line
+func describeAuditChanges(changes map[string]FieldChange) string {
+	fields := make([]string, 0, len(changes))
+	for field := range changes {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	parts := make([]string, 0, len(fields))
+	for _, field := range fields {
+		change := changes[field]
+		from, to := truncate(change.From, 40), truncate(change.To, 40)
+		if from == "" {
+			from = "∅"
+		}
+		if to == "" {
+			to = "∅"
+		}
+		parts = append(parts, fmt.Sprintf("%s: %s → %s", field, from, to))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// handleAuditExport returns audit entries as JSON to system admins. The
+// optional query parameters ticket, action, since and until (Unix
+// milliseconds) narrow the export.
+func (p *Plugin) handleAuditExport(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+	if userID == "" {
+		p.writeAPIError(w, http.StatusUnauthorized, "Not authorized")
+		return
+	}
+	if !p.API.HasPermissionTo(userID, model.PermissionManageSystem) {
+		p.writeAPIError(w, http.StatusForbidden, "Only system admins can export the audit log")
+		return
+	}
+
+	query := r.URL.Query()
+	var ticket *Ticket
+	if ref := query.Get("ticket"); ref != "" {
+		var err error
+		ticket, err = p.findTicket(ref)
+		if err != nil {
+			p.API.LogError("Failed to get ticket for audit export", "error", err.Error(), "ticket", ref)
+			p.writeAPIError(w, http.StatusInternalServerError, "Failed to get ticket")
+			return
+		}
+		if ticket == nil {
+			p.writeAPIError(w, http.StatusNotFound, "Ticket not found")
+			return
+		}
+	}
+
+	var since, until int64
+	for name, target := range map[string]*int64{"since": &since, "until": &until} {
+		if raw := query.Get(name); raw != "" {
+			value, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil {
+				p.writeAPIError(w, http.StatusBadRequest, name+" must be a Unix time in milliseconds")
+				return
+			}
+			*target = value
+		}
+	}
+	action := query.Get("action")
+	if action != "" && !strings.HasPrefix(action, "ticket.") {
+		action = "ticket." + action
+	}
+
+	var entries []*AuditEntry
+	var err error
+	if ticket != nil {
+		entries, err = p.listAuditEntries(ticket)
+	} else {
+		entries, err = p.listAllAuditEntries()
+	}
+	if err != nil {
+		p.API.LogError("Failed to list audit entries", "error", err.Error())
+		p.writeAPIError(w, http.StatusInternalServerError, "Failed to load the audit log")
+		return
+	}
+
+	matching := make([]*AuditEntry, 0, len(entries))
+	for _, entry := range entries {
+		if (action != "" && entry.Action != action) || (since > 0 && entry.CreatedAt < since) || (until > 0 && entry.CreatedAt > until) {
+			continue
+		}
+		matching = append(matching, entry)
+	}
+
+	p.writeJSON(w, http.StatusOK, matching)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+// ChannelProfile overrides the global ticket settings for one channel. Empty
+// fields fall back to the global settings; permissions replace the global
+// policy per action and reactions replace the global emoji mapping.
+type ChannelProfile struct {
+	TeamOptions        json.RawMessage     `json:"team_options"`
+	ProjectOptions     json.RawMessage     `json:"project_options"`
+	Mentions           map[string][]string `json:"mentions"`
+	DefaultEnvironment string              `json:"default_environment"`
+	DefaultPriority    string              `json:"default_priority"`
+	Permissions        *TransitionPolicy   `json:"permissions"`
+	Reactions          map[string]string   `json:"reactions"`
+
+	teamOptions    []*model.PostActionOptions
+	projectOptions []*model.PostActionOptions
+	reactions      map[string]string
+}
+
+// parse validates the profile of the channel key
+func (c *ChannelProfile) parse(key string) error {
+	var err error
+	name := "ChannelProfilesConfig: " + key + " team_options"
+	if c.teamOptions, err = parseOptionsSetting(name, string(c.TeamOptions), nil); err != nil {
+		return err
+	}
+	name = "ChannelProfilesConfig: " + key + " project_options"
+	if c.projectOptions, err = parseOptionsSetting(name, string(c.ProjectOptions), nil); err != nil {
+		return err
+	}
+
+	if c.DefaultEnvironment != "" && !hasOption(environmentOptions, c.DefaultEnvironment) {
+		return errors.Errorf("ChannelProfilesConfig: %s has unknown default_environment %q", key, c.DefaultEnvironment)
+	}
+	if c.DefaultPriority != "" && !hasOption(priorityOptions, c.DefaultPriority) {
+		return errors.Errorf("ChannelProfilesConfig: %s has unknown default_priority %q", key, c.DefaultPriority)
+	}
+
+	if c.Permissions != nil {
+		if err := c.Permissions.validate(); err != nil {
+			return errors.Wrapf(err, "ChannelProfilesConfig: %s permissions", key)
+		}
+	}
+	if c.reactions, err = parseReactionActions("ChannelProfilesConfig: "+key+" reactions", c.Reactions); err != nil {
+		return err
+	}
+
+	for team, users := range c.Mentions {
+		for i, username := range users {
+			c.Mentions[team][i] = strings.TrimPrefix(strings.TrimSpace(username), "@")
+		}
+	}
+	return nil
+}
+
+// parseChannelProfiles validates the `ChannelProfilesConfig` setting. Profiles
+// are keyed by channel ID or name; names are matched case-insensitively.
+func parseChannelProfiles(raw string) (map[string]*ChannelProfile, error) {
+	var profiles map[string]*ChannelProfile
+	if err := parseJSONSetting("ChannelProfilesConfig", raw, &profiles); err != nil {
+		return nil, err
+	}
+
+	parsed := make(map[string]*ChannelProfile, len(profiles))
+	for key, profile := range profiles {
+		if profile == nil {
+			continue
+		}
+		if err := profile.parse(key); err != nil {
+			return nil, err
+		}
+		normalized := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(key), "~"))
+		if _, exists := parsed[normalized]; exists {
+			return nil, errors.Errorf("ChannelProfilesConfig: duplicate channel %q", key)
+		}
+		parsed[normalized] = profile
+	}
+	return parsed, nil
+}
+
+// getChannelProfile returns the profile configured for the channel ID or
+// name, or nil when the channel uses the global settings
+func (p *Plugin) getChannelProfile(channelId string) *ChannelProfile {
+	profiles := p.getConfiguration().channelProfiles
+	if len(profiles) == 0 || channelId == "" {
+		return nil
+	}
+
+	if profile, ok := profiles[strings.ToLower(channelId)]; ok {
+		return profile
+	}
+	if name := p.getChannelName(channelId); name != "" {
+		return profiles[strings.ToLower(name)]
+	}
+	return nil
+}
+
+// applyTicketDefaults fills in the environment and priority left empty by
+// the reporter. The priority comes from the ticket type, then the channel
+// profile; the environment from the channel profile.
+func (p *Plugin) applyTicketDefaults(ticketData *TicketDialog, channelId string) {
+	profile := p.getChannelProfile(channelId)
+
+	if ticketData.Environment == "" && profile != nil {
+		ticketData.Environment = profile.DefaultEnvironment
+	}
+
+	if ticketData.Priority == "" {
+		if ticketType := p.getTicketType(ticketData.Type); ticketType != nil && ticketType.DefaultPriority != "" {
+			ticketData.Priority = ticketType.DefaultPriority
+		} else if profile != nil && profile.DefaultPriority != "" {
+			ticketData.Priority = profile.DefaultPriority
+		} else {
+			ticketData.Priority = "standard"
+		}
+	}
+}
@@ -2,12 +2,26 @@ package main
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/mattermost/mattermost/server/public/plugin"
 )
 
+// ticketCommandUsage lists the /ticket subcommands
+const ticketCommandUsage = "Usage:\n" +
+	"* `/ticket` - Create a new ticket\n" +
+	"* `/ticket <type>` - Create a ticket of a configured type, e.g. `/ticket bug`\n" +
+	"* `/ticket assign <ticket> @user` - Assign a ticket\n" +
+	"* `/ticket edit <ticket>` - Edit the details of a ticket\n" +
+	"* `/ticket audit <ticket>` - Show who changed a ticket and when\n" +
+	"* `/ticket list [--status <status>] [--team <team>] [--project <project>] [--env <environment>] [--priority <priority>] [--type <type>] [--assignee @user] [--mine] [--page <n>]` - List tickets in this channel\n" +
+	"* `/ticket preview [template] [ticket]` - Preview a message template"
+
+// ticketListPageSize is the number of tickets shown per page by /ticket list
+const ticketListPageSize = 10
+
 // handleTicketCommand handles the /ticket slash command
 func (p *Plugin) handleTicketCommand(c *plugin.Context, args *model.CommandArgs) (*model.CommandResponse, *model.AppError) {
 	if !p.validateChannel(args.ChannelId) {
@@ -24,61 +38,79 @@ func (p *Plugin) handleTicketCommand(c *plugin.Context, args *model.CommandArgs)
 		}, nil
 	}
 
+	parts := strings.Fields(args.Command)
+	if len(parts) > 1 {
+		switch strings.ToLower(parts[1]) {
+		case "assign":
+			return p.handleAssignCommand(args, parts[2:]), nil
+		case "list":
+			return p.handleListCommand(args, parts[2:]), nil
+		case "audit":
+			return p.handleAuditCommand(args, parts[2:]), nil
+		case "edit":
+			return p.handleEditCommand(args, parts[2:]), nil
+		case "preview":
+			return p.handlePreviewCommand(args, parts[2:]), nil
+		default:
+			ticketType := p.getTicketType(parts[1])
+			if ticketType == nil {
+				return ephemeralResponse(p.ticketCommandHelp()), nil
+			}
+			return p.openTicketDialog(args, ticketType), nil
+		}
+	}
+
+	return p.openTicketDialog(args, nil), nil
+}
+
+// ticketCommandHelp returns the /ticket usage, including the configured ticket types
+func (p *Plugin) ticketCommandHelp() string {
+	types := p.getTicketTypes()
+	if len(types) == 0 {
+		return ticketCommandUsage
+	}
+
+	help := ticketCommandUsage + "\n\nTicket types:"
+	for _, ticketType := range types {
+		help += fmt.Sprintf("\n* `/ticket %s` - %s", ticketType.ID, ticketType.Name)
+		if ticketType.Description != "" {
+			help += ": " + ticketType.Description
+		}
+	}
+	return help
+}
+
+// openTicketDialog opens the create dialog for the ticket type, or the
+// generic dialog when ticketType is nil
+func (p *Plugin) openTicketDialog(args *model.CommandArgs, ticketType *TicketType) *model.CommandResponse {
+	if reason := p.ticketCreationDisabled(args.ChannelId); reason != "" {
+		return ephemeralResponse(reason)
+	}
+
+	title, typeID := "Create New Ticket", ""
+	if ticketType != nil {
+		title, typeID = ticketType.dialogTitle(), ticketType.ID
+	}
+
+	// Preselect the type and channel defaults; develop stays the fallback environment
+	defaults := TicketDialog{Type: typeID}
+	p.applyTicketDefaults(&defaults, args.ChannelId)
+	if defaults.Environment == "" {
+		defaults.Environment = "develop"
+	}
+	defaults.Fields = withCustomFieldDefaults(p.getCustomFields(typeID), nil)
+
 	dialog := model.OpenDialogRequest{
 		TriggerId: args.TriggerId,
 		URL:       fmt.Sprintf("/plugins/%s/api/v1/dialog", pluginID),
 		Dialog: model.Dialog{
-			Title:            "Create New Ticket",
+			Title:            title,
 			IntroductionText: "Please fill in the details for your ticket:",
-			Elements: []model.DialogElement{
-				{
-					DisplayName: "Team Name",
-					Name:        "team_name",
-					Type:        "select",
-					Placeholder: "Select your team",
-					Options:     p.getTeamOptions(),
-				},
-				{
-					DisplayName: "Project Name",
-					Name:        "project_name",
-					Type:        "select",
-					Placeholder: "Select issue project",
-					Options:     p.getProjectOptions(),
-				},
-				{
-					DisplayName: "Environment",
-					Name:        "environment",
-					Type:        "select",
-					Placeholder: "Select environment",
-					Options:     environmentOptions,
-					Default:     "develop",
-				},
-				{
-					DisplayName: "Message Priority",
-					Name:        "priority",
-					Type:        "select",
-					Placeholder: "Select priority",
-					Options:     priorityOptions,
-					Default:     "standard",
-				},
-				{
-					DisplayName: "Summary",
-					Name:        "summary",
-					Type:        "text",
-					Placeholder: "Short description",
-					MaxLength:   1000,
-					Optional:    true,
-				},
-				{
-					DisplayName: "Issue Description",
-					Name:        "description",
-					Type:        "textarea",
-					Placeholder: "Describe the issue in detail...",
-					MaxLength:   2000,
-				},
-			},
-			SubmitLabel:    "Create Ticket",
-			NotifyOnCancel: true,
+			Elements:         p.ticketDialogElements(args.ChannelId, defaults),
+			SubmitLabel:      "Create Ticket",
+			NotifyOnCancel:   true,
+			// The submit handler reads the ticket type back from the state
+			State: typeID,
 		},
 	}
 
@@ -86,10 +118,240 @@ func (p *Plugin) handleTicketCommand(c *plugin.Context, args *model.CommandArgs)
 		return &model.CommandResponse{
 			ResponseType: model.CommandResponseTypeEphemeral,
 			Text:         "Failed to open ticket dialog: " + err.Error(),
-		}, nil
+		}
 	}
 
-	return &model.CommandResponse{}, nil
+	return &model.CommandResponse{}
+}
+
+// ticketDialogElements returns the elements of the create and edit dialogs
+// for the channel, preselecting the given values
+func (p *Plugin) ticketDialogElements(channelId string, values TicketDialog) []model.DialogElement {
+	elements := []model.DialogElement{
+		{
+			DisplayName: "Team Name",
+			Name:        "team_name",
+			Type:        "select",
+			Placeholder: "Select your team",
+			Default:     values.TeamName,
+			Options:     p.getTeamOptions(channelId),
+		},
+		{
+			DisplayName: "Project Name",
+			Name:        "project_name",
+			Type:        "select",
+			Placeholder: "Select issue project",
+			Default:     values.ProjectName,
+			Options:     p.getProjectOptions(channelId),
+		},
+		{
+			DisplayName: "Environment",
+			Name:        "environment",
+			Type:        "select",
+			Placeholder: "Select environment",
+			Options:     environmentOptions,
+			Default:     values.Environment,
+		},
+		{
+			DisplayName: "Message Priority",
+			Name:        "priority",
+			Type:        "select",
+			Placeholder: "Select priority",
+			Options:     priorityOptions,
+			Default:     values.Priority,
+		},
+		{
+			DisplayName: "Summary",
+			Name:        "summary",
+			Type:        "text",
+			Placeholder: "Short description",
+			Default:     values.Summary,
+			MaxLength:   1000,
+			Optional:    true,
+		},
+		{
+			DisplayName: "Issue Description",
+			Name:        "description",
+			Type:        "textarea",
+			Placeholder: "Describe the issue in detail...",
+			Default:     values.Description,
+			MaxLength:   2000,
+		},
+	}
+	for _, field := range p.getCustomFields(values.Type) {
+		element := field.dialogElement()
+		element.Default = values.Fields[field.Name]
+		elements = append(elements, element)
+	}
+	return elements
+}
+
+// handleAssignCommand handles `/ticket assign <ticket> @user`
+func (p *Plugin) handleAssignCommand(args *model.CommandArgs, params []string) *model.CommandResponse {
+	if len(params) < 2 {
+		return ephemeralResponse("Usage: /ticket assign <ticket> @user\nExample: /ticket assign TCK-142 @jane")
+	}
+
+	ticket, err := p.findTicket(params[0])
+	if err != nil {
+		return ephemeralResponse("Failed to find ticket: " + err.Error())
+	}
+	if ticket == nil {
+		return ephemeralResponse(fmt.Sprintf("Ticket `%s` was not found. Please use a ticket number such as TCK-142.", params[0]))
+	}
+
+	if reason := p.checkTicketAccess(ticket, args.UserId); reason != "" {
+		return ephemeralResponse(reason)
+	}
+
+	if p.getWorkflow(ticket.Type).IsDone(ticket.Status) {
+		return ephemeralResponse("This ticket is already resolved.")
+	}
+
+	username := strings.TrimPrefix(params[1], "@")
+	user, appErr := p.API.GetUserByUsername(username)
+	if appErr != nil {
+		return ephemeralResponse(fmt.Sprintf("User `@%s` was not found.", username))
+	}
+
+	if reason := p.checkAssignee(ticket, user.Id); reason != "" {
+		return ephemeralResponse(reason)
+	}
+
+	if err := p.assignTicket(ticket, user.Id, args.UserId, SourceCommand); err != nil {
+		return ephemeralResponse("Failed to assign ticket: " + err.Error())
+	}
+
+	return ephemeralResponse(fmt.Sprintf("Ticket %s assigned to @%s.", ticket.Key(), user.Username))
+}
+
+// handleEditCommand handles `/ticket edit <ticket>` and opens the edit dialog
+func (p *Plugin) handleEditCommand(args *model.CommandArgs, params []string) *model.CommandResponse {
+	if len(params) < 1 {
+		return ephemeralResponse("Usage: /ticket edit <ticket>\nExample: /ticket edit TCK-142")
+	}
+
+	ticket, err := p.findTicket(params[0])
+	if err != nil {
+		return ephemeralResponse("Failed to find ticket: " + err.Error())
+	}
+	if ticket == nil || !p.API.HasPermissionToChannel(args.UserId, ticket.ChannelID, model.PermissionReadChannel) {
+		return ephemeralResponse(fmt.Sprintf("Ticket `%s` was not found. Please use a ticket number such as TCK-142.", params[0]))
+	}
+
+	if reason := p.checkTicketAccess(ticket, args.UserId); reason != "" {
+		return ephemeralResponse(reason)
+	}
+
+	if err := p.openEditTicketDialog(args.TriggerId, ticket); err != nil {
+		return ephemeralResponse("Failed to open edit dialog: " + err.Error())
+	}
+	return &model.CommandResponse{}
+}
+
+// handleListCommand handles `/ticket list` and shows the matching tickets of
+// the current channel as a table
+func (p *Plugin) handleListCommand(args *model.CommandArgs, params []string) *model.CommandResponse {
+	filter := &TicketFilter{ChannelID: args.ChannelId}
+	page := 1
+
+	for i := 0; i < len(params); i++ {
+		flag := strings.ToLower(params[i])
+		if flag == "--mine" {
+			filter.AssigneeID = args.UserId
+			continue
+		}
+
+		if i+1 >= len(params) {
+			return ephemeralResponse(fmt.Sprintf("Missing value for `%s`.\n\n%s", flag, ticketCommandUsage))
+		}
+		i++
+		value := params[i]
+
+		switch flag {
+		case "--status":
+			filter.Status = value
+		case "--team":
+			filter.TeamName = value
+		case "--project":
+			filter.ProjectName = value
+		case "--env", "--environment":
+			filter.Environment = value
+		case "--priority":
+			filter.Priority = value
+		case "--type":
+			filter.Type = value
+		case "--assignee":
+			username := strings.TrimPrefix(value, "@")
+			user, appErr := p.API.GetUserByUsername(username)
+			if appErr != nil {
+				return ephemeralResponse(fmt.Sprintf("User `@%s` was not found.", username))
+			}
+			filter.AssigneeID = user.Id
+		case "--page":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return ephemeralResponse(fmt.Sprintf("Invalid page `%s`.", value))
+			}
+			page = n
+		default:
+			return ephemeralResponse(fmt.Sprintf("Unknown option `%s`.\n\n%s", flag, ticketCommandUsage))
+		}
+	}
+
+	tickets, total, err := p.listTickets(filter, page-1, ticketListPageSize)
+	if err != nil {
+		return ephemeralResponse("Failed to list tickets: " + err.Error())
+	}
+
+	if total == 0 {
+		return ephemeralResponse("No tickets match these filters.")
+	}
+
+	pages := (total + ticketListPageSize - 1) / ticketListPageSize
+	if len(tickets) == 0 {
+		return ephemeralResponse(fmt.Sprintf("Page %d is out of range. There are %d pages.", page, pages))
+	}
+
+	teamName := ""
+	if team, appErr := p.API.GetTeam(args.TeamId); appErr == nil {
+		teamName = team.Name
+	}
+
+	var b strings.Builder
+	b.WriteString("| Ticket | Summary | Team | Project | Environment | Priority | Status | Assignee |\n")
+	b.WriteString("|:--|:--|:--|:--|:--|:--|:--|:--|\n")
+	for _, ticket := range tickets {
+		assignee := "Unassigned"
+		if ticket.AssigneeID != "" {
+			assignee = "@" + p.getUsername(ticket.AssigneeID)
+		}
+		fmt.Fprintf(&b, "| [%s](%s) | %s | %s | %s | %s | %s | %s | %s |\n",
+			ticket.Key(),
+			ticketPermalink(args.SiteURL, teamName, ticket.PostID),
+			escapeTableCell(truncate(ticket.Summary, 60)),
+			escapeTableCell(ticket.TeamName),
+			escapeTableCell(ticket.ProjectName),
+			escapeTableCell(ticket.Environment),
+			escapeTableCell(ticket.Priority),
+			escapeTableCell(p.getWorkflow(ticket.Type).StatusName(ticket.Status)),
+			assignee)
+	}
+
+	fmt.Fprintf(&b, "\nShowing page %d of %d (%d tickets).", page, pages, total)
+	if page < pages {
+		fmt.Fprintf(&b, " Add `--page %d` to see more.", page+1)
+	}
+
+	return ephemeralResponse(b.String())
+}
+
+// ephemeralResponse builds a command response only visible to the caller
+func ephemeralResponse(text string) *model.CommandResponse {
+	return &model.CommandResponse{
+		ResponseType: model.CommandResponseTypeEphemeral,
+		Text:         text,
+	}
 }
 
 // handleResolveCommand handles the /resolve slash command
@@ -112,61 +374,57 @@ func (p *Plugin) handleResolveCommand(c *plugin.Context, args *model.CommandArgs
 	if len(parts) < 2 {
 		return &model.CommandResponse{
 			ResponseType: model.CommandResponseTypeEphemeral,
-			Text:         "Usage: /resolve <post_id>\nExample: /resolve abc123def456",
+			Text:         "Usage: /resolve <ticket>\nExample: /resolve TCK-142",
 		}, nil
 	}
 
-	postId := parts[1]
-
-	post, err := p.API.GetPost(postId)
+	ticket, err := p.findTicket(parts[1])
 	if err != nil {
 		return &model.CommandResponse{
 			ResponseType: model.CommandResponseTypeEphemeral,
-			Text:         "Failed to find post: " + err.Error(),
+			Text:         "Failed to find ticket: " + err.Error(),
 		}, nil
 	}
 
-	if !strings.Contains(post.Message, "🎫 **New Ticket Created**") {
+	if ticket == nil {
 		return &model.CommandResponse{
 			ResponseType: model.CommandResponseTypeEphemeral,
-			Text:         "This post is not a ticket. Please use the post ID of a ticket.",
+			Text:         fmt.Sprintf("Ticket `%s` was not found. Please use a ticket number such as TCK-142.", parts[1]),
 		}, nil
 	}
 
-	if strings.Contains(post.Message, "✅ **Status:** Resolved") {
+	if reason := p.checkTicketAccess(ticket, args.UserId); reason != "" {
+		return ephemeralResponse(reason), nil
+	}
+
+	workflow := p.getWorkflow(ticket.Type)
+	if workflow.IsDone(ticket.Status) {
 		return &model.CommandResponse{
 			ResponseType: model.CommandResponseTypeEphemeral,
 			Text:         "This ticket is already resolved.",
 		}, nil
 	}
 
-	// Update post to resolved status
-	updatePost := post.Clone()
-	updatePost.Message = strings.Replace(updatePost.Message, "**Status:** Open", "✅ **Status:** Resolved", 1)
-	updatePost.Message = strings.Replace(updatePost.Message, "💡 **To mark as resolved:** Use `/resolve "+postId+"`", "", 1)
-
-	// Add reopen button
-	p.attachReopenButton(updatePost, postId, post.ChannelId)
-
-	if _, err := p.API.UpdatePost(updatePost); err != nil {
+	if !workflow.CanTransition(ticket.Status, TicketStatusResolved) {
 		return &model.CommandResponse{
 			ResponseType: model.CommandResponseTypeEphemeral,
-			Text:         "Failed to update post: " + err.Error(),
+			Text:         fmt.Sprintf("A ticket in status **%s** cannot be resolved directly.", workflow.StatusName(ticket.Status)),
 		}, nil
 	}
 
-	replyPost := &model.Post{
-		ChannelId: post.ChannelId,
-		UserId:    args.UserId,
-		Message:   "✅ Resolved",
-		Type:      model.PostTypeDefault,
-		RootId:    postId,
+	if reason := p.transitionDisabled(workflow, ticket.Status, TicketStatusResolved); reason != "" {
+		return ephemeralResponse(reason), nil
+	}
+
+	if reason := p.transitionDenied(ticket, workflow, ticket.Status, TicketStatusResolved, args.UserId); reason != "" {
+		return ephemeralResponse(reason), nil
 	}
 
-	if _, err := p.API.CreatePost(replyPost); err != nil {
+	// Update ticket to resolved status
+	if err := p.transitionTicket(ticket, TicketStatusResolved, args.UserId, SourceCommand); err != nil {
 		return &model.CommandResponse{
 			ResponseType: model.CommandResponseTypeEphemeral,
-			Text:         "Failed to create reply: " + err.Error(),
+			Text:         "Failed to update ticket: " + err.Error(),
 		}, nil
 	}
 
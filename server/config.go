@@ -1,115 +1,123 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
+	"regexp"
 	"strings"
 
 	"github.com/mattermost/mattermost/server/public/model"
 )
 
-// getTicketMentionUsers returns the users that should be mentioned in ticket
+// ticketPrefixPattern restricts ticket prefixes to short upper-case identifiers
+var ticketPrefixPattern = regexp.MustCompile(`^[A-Z][A-Z0-9]{0,9}$`)
+
+// getTicketMentionUsers returns the users that should be mentioned in ticket.
+// A channel profile with mentions replaces the global map: its team entry and
+// its "*" entry are mentioned.
 func (p *Plugin) getTicketMentionUsers(teamName string, channelId string) []string {
+	if profile := p.getChannelProfile(channelId); profile != nil && len(profile.Mentions) > 0 {
+		var members []string
+		members = append(members, profile.Mentions[teamName]...)
+		members = append(members, profile.Mentions["*"]...)
+		return members
+	}
+
+	mentions := p.getConfiguration().mentions
+	if len(mentions) == 0 {
+		return nil
+	}
+
 	var teamMembers []string
 
-	config := p.API.GetConfig()
-	if config != nil && config.PluginSettings.Plugins[pluginID] != nil {
-		teamMembersConfig := config.PluginSettings.Plugins[pluginID]["ticketmentionconfig"]
-
-		if teamMembersConfig != nil {
-			teamMembersStr := teamMembersConfig.(string)
-			if teamMembersStr != "" {
-				var teamMembersMap map[string][]string
-				if err := json.Unmarshal([]byte(teamMembersStr), &teamMembersMap); err == nil {
-					// Check one channel teamName
-					channelName := p.getChannelName(channelId)
-					configName := fmt.Sprintf("%s__%s", channelName, teamName)
-					if members, exists := teamMembersMap[configName]; exists {
-						teamMembers = append(teamMembers, members...)
-					}
-
-					// Always add channelId members if they exist
-					if allMembers, exists := teamMembersMap[channelName]; exists {
-						teamMembers = append(teamMembers, allMembers...)
-					}
-				} else {
-					p.API.LogError("Failed to parse team members config", "error", err.Error(), "rawConfig", teamMembersStr, "configBytes", []byte(teamMembersStr))
-				}
-			}
-		}
+	// Check one channel teamName
+	channelName := p.getChannelName(channelId)
+	configName := fmt.Sprintf("%s__%s", channelName, teamName)
+	if members, exists := mentions[configName]; exists {
+		teamMembers = append(teamMembers, members...)
+	}
+
+	// Always add channelId members if they exist
+	if allMembers, exists := mentions[channelName]; exists {
+		teamMembers = append(teamMembers, allMembers...)
 	}
 
 	return teamMembers
 }
 
-// getTeamOptions returns the team options from configuration or falls back to defaults
-func (p *Plugin) getTeamOptions() []*model.PostActionOptions {
-	config := p.API.GetConfig()
-	if config != nil && config.PluginSettings.Plugins[pluginID] != nil {
-		teamOptionsConfig := config.PluginSettings.Plugins[pluginID]["teamoptionsconfig"]
-
-		if teamOptionsConfig != nil {
-			raw := teamOptionsConfig.(string)
-			if raw != "" {
-				type option struct {
-					Text  string `json:"Text"`
-					Value string `json:"Value"`
-				}
-				var parsed []option
-				if err := json.Unmarshal([]byte(raw), &parsed); err == nil {
-					var opts []*model.PostActionOptions
-					for _, o := range parsed {
-						if o.Text == "" || o.Value == "" {
-							continue
-						}
-						opts = append(opts, &model.PostActionOptions{Text: o.Text, Value: o.Value})
-					}
-					if len(opts) > 0 {
-						return opts
-					}
-				} else {
-					p.API.LogError("Failed to parse team options config", "error", err.Error(), "rawConfig", raw)
-				}
-			}
-		}
+// getTeamOptions returns the team options of the channel profile, the
+// configuration or falls back to defaults
+func (p *Plugin) getTeamOptions(channelId string) []*model.PostActionOptions {
+	if profile := p.getChannelProfile(channelId); profile != nil && len(profile.teamOptions) > 0 {
+		return profile.teamOptions
 	}
+	return p.getConfiguration().teamOptions
+}
 
-	return teamOptions
-}
-
-// getProjectOptions returns the project options from configuration or falls back to defaults
-func (p *Plugin) getProjectOptions() []*model.PostActionOptions {
-	config := p.API.GetConfig()
-	if config != nil && config.PluginSettings.Plugins[pluginID] != nil {
-		projectOptionsConfig := config.PluginSettings.Plugins[pluginID]["projectoptionsconfig"]
-
-		if projectOptionsConfig != nil {
-			raw := projectOptionsConfig.(string)
-			if raw != "" {
-				type option struct {
-					Text  string `json:"Text"`
-					Value string `json:"Value"`
-				}
-				var parsed []option
-				if err := json.Unmarshal([]byte(raw), &parsed); err == nil {
-					var opts []*model.PostActionOptions
-					for _, o := range parsed {
-						if o.Text == "" || o.Value == "" {
-							continue
-						}
-						opts = append(opts, &model.PostActionOptions{Text: o.Text, Value: o.Value})
-					}
-					if len(opts) > 0 {
-						return opts
-					}
-				} else {
-					p.API.LogError("Failed to parse project options config", "error", err.Error(), "rawConfig", raw)
-				}
-			}
+// getProjectOptions returns the project options of the channel profile, the
+// configuration or falls back to defaults
+func (p *Plugin) getProjectOptions(channelId string) []*model.PostActionOptions {
+	if profile := p.getChannelProfile(channelId); profile != nil && len(profile.projectOptions) > 0 {
+		return profile.projectOptions
+	}
+	return p.getConfiguration().projectOptions
+}
+
+// getTicketPrefix returns the ticket number prefix configured for the channel,
+// falling back to the default prefix. The `TicketPrefixConfig` setting maps
+// channel names to prefixes, e.g. {"tickets": "TCK", "support": "SUP"}.
+func (p *Plugin) getTicketPrefix(channelId string) string {
+	prefixes := p.getConfiguration().prefixes
+	if len(prefixes) == 0 {
+		return defaultTicketPrefix
+	}
+
+	if prefix, exists := prefixes[p.getChannelName(channelId)]; exists {
+		return prefix
+	}
+	return defaultTicketPrefix
+}
+
+// getWorkflow returns the workflow of the ticket type, or the one from the
+// `TicketWorkflowConfig` setting, falling back to the default Open/Resolved workflow
+func (p *Plugin) getWorkflow(ticketType string) *Workflow {
+	if t := p.getTicketType(ticketType); t != nil && t.Workflow != nil {
+		return t.Workflow
+	}
+	return p.getConfiguration().workflow
+}
+
+// getSLATarget returns the SLA configured for the priority and environment.
+// The `SLAConfig` setting maps "priority/environment" keys to durations, e.g.
+// {"urgent/production": {"response": "15m", "resolution": "4h"}}. Either side
+// of the key may be "*"; the most specific match wins.
+func (p *Plugin) getSLATarget(priority, environment string) (*SLATarget, bool) {
+	targets := p.getConfiguration().slaTargets
+	for _, key := range []string{priority + "/" + environment, priority + "/*", "*/" + environment, "*/*"} {
+		if target, exists := targets[key]; exists {
+			return target, true
 		}
 	}
+	return nil, false
+}
+
+// getSLAEscalationUsers returns the usernames mentioned when an SLA is breached
+func (p *Plugin) getSLAEscalationUsers() []string {
+	return p.getConfiguration().escalationUsers
+}
 
-	return projectOptions
+// getWebhookSubscriptions returns the outbound webhook subscriptions from the
+// `WebhookSubscriptionsConfig` setting
+func (p *Plugin) getWebhookSubscriptions() []*WebhookSubscription {
+	return p.getConfiguration().webhookSubscriptions
+}
+
+// getIncomingWebhook returns the inbound webhook with the given ID from the
+// `IncomingWebhooksConfig` setting, or nil if there is none
+func (p *Plugin) getIncomingWebhook(hookID string) *IncomingWebhook {
+	if hookID == "" {
+		return nil
+	}
+	return p.getConfiguration().incomingWebhooks[hookID]
 }
 
 // getAllowedChannels returns the list of channel names that are allowed to use the plugin.
@@ -117,31 +125,67 @@ func (p *Plugin) getProjectOptions() []*model.PostActionOptions {
 // a comma-separated or newline-separated list of channel names. If empty, all
 // channels are allowed.
 func (p *Plugin) getAllowedChannels() []string {
-	config := p.API.GetConfig()
-	if config == nil || config.PluginSettings.Plugins[pluginID] == nil {
-		return nil
+	return p.getConfiguration().allowedChannels
+}
+
+// ticketCreationDisabled returns why new tickets cannot be created in the
+// channel, or an empty string when creation is allowed. `EnableTicketCreation`
+// turns creation off everywhere and `FrozenChannels` freezes single channels.
+func (p *Plugin) ticketCreationDisabled(channelId string) string {
+	config := p.getConfiguration()
+	if !isEnabled(config.EnableTicketCreation) {
+		return "Ticket creation is currently disabled by your system administrator."
 	}
 
-	rawSetting := config.PluginSettings.Plugins[pluginID]["defaultchannel"]
-	raw, ok := rawSetting.(string)
-	if !ok || strings.TrimSpace(raw) == "" {
-		return nil
+	if len(config.frozenChannels) > 0 {
+		channelName := p.getChannelName(channelId)
+		for _, name := range config.frozenChannels {
+			if strings.EqualFold(channelName, name) {
+				return "Ticket creation is frozen in this channel. Please try again later or contact your system administrator."
+			}
+		}
+	}
+	return ""
+}
+
+// transitionDisabled returns why a status change is switched off, or an empty
+// string when it is allowed. Moving into a done status is resolving and moving
+// out of one is reopening.
+func (p *Plugin) transitionDisabled(workflow *Workflow, from, to string) string {
+	config := p.getConfiguration()
+	fromDone, toDone := workflow.IsDone(from), workflow.IsDone(to)
+	if !fromDone && toDone && !isEnabled(config.EnableResolve) {
+		return "Resolving tickets is currently disabled by your system administrator."
+	}
+	if fromDone && !toDone && !isEnabled(config.EnableReopen) {
+		return "Reopening tickets is currently disabled by your system administrator."
 	}
+	return ""
+}
 
+// externalIntakeEnabled reports whether incoming webhooks and Alertmanager
+// notifications are accepted
+func (p *Plugin) externalIntakeEnabled() bool {
+	return isEnabled(p.getConfiguration().EnableExternalIntake)
+}
+
+// splitList parses a comma-separated or newline-separated setting into its
+// trimmed, non-empty entries
+func splitList(raw string) []string {
 	normalized := strings.ReplaceAll(raw, "\n", ",")
 	parts := strings.Split(normalized, ",")
-	var channels []string
+	var items []string
 	for _, part := range parts {
-		name := strings.TrimSpace(part)
-		if name == "" {
+		item := strings.TrimSpace(part)
+		if item == "" {
 			continue
 		}
-		channels = append(channels, name)
+		items = append(items, item)
 	}
-	if len(channels) == 0 {
+	if len(items) == 0 {
 		return nil
 	}
-	return channels
+	return items
 }
 
 // validateChannel checks if the given channel ID matches the default channel
@@ -160,6 +204,16 @@ func (p *Plugin) validateChannel(channelId string) bool {
 	return false
 }
 
+// getUsername returns the username for a user ID, falling back to the ID itself
+func (p *Plugin) getUsername(userId string) string {
+	user, err := p.API.GetUser(userId)
+	if err != nil {
+		p.API.LogError("Failed to get user", "error", err.Error(), "user_id", userId)
+		return userId
+	}
+	return user.Username
+}
+
 // Get channel name from it's ID
 func (p *Plugin) getChannelName(channelId string) string {
 	channel, err := p.API.GetChannel(channelId)
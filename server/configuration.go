@@ -0,0 +1,381 @@
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+	"text/template"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+// configuration captures the plugin settings from plugin.json as stored in the
+// server configuration, together with the values parsed from them. It is
+// loaded once per change in OnConfigurationChange and treated as immutable
+// afterwards: a change swaps in a new configuration instead of mutating it.
+type configuration struct {
+	EnableTicketCreation        *bool
+	EnableResolve               *bool
+	EnableReopen                *bool
+	EnableExternalIntake        *bool
+	FrozenChannels              string
+	DefaultChannel              string
+	TicketMentionConfig         string
+	TeamOptionsConfig           string
+	ProjectOptionsConfig        string
+	TicketPrefixConfig          string
+	TicketWorkflowConfig        string
+	SLAConfig                   string
+	SLAEscalationUsers          string
+	WebhookSubscriptionsConfig  string
+	IncomingWebhooksConfig      string
+	CustomFieldsConfig          string
+	TicketTypesConfig           string
+	ChannelProfilesConfig       string
+	TransitionPermissionsConfig string
+	ReactionActionsConfig       string
+	TicketPostTemplate          string
+	ResolvedReplyTemplate       string
+	ReopenedReplyTemplate       string
+	AttachmentTextTemplate      string
+
+	allowedChannels      []string
+	frozenChannels       []string
+	mentions             map[string][]string
+	teamOptions          []*model.PostActionOptions
+	projectOptions       []*model.PostActionOptions
+	prefixes             map[string]string
+	workflow             *Workflow
+	slaTargets           map[string]*SLATarget
+	escalationUsers      []string
+	webhookSubscriptions []*WebhookSubscription
+	incomingWebhooks     map[string]*IncomingWebhook
+	customFields         []*CustomField
+	ticketTypes          []*TicketType
+	channelProfiles      map[string]*ChannelProfile
+	transitionPolicy     *TransitionPolicy
+	reactionActions      map[string]string
+	templates            map[string]*template.Template
+}
+
+// parse validates the raw settings and fills in the parsed values. Every
+// problem found is reported so an admin can fix them all in one go.
+func (c *configuration) parse() error {
+	var problems []string
+	report := func(err error) {
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	c.allowedChannels = splitList(c.DefaultChannel)
+	c.frozenChannels = splitList(c.FrozenChannels)
+	report(parseJSONSetting("TicketMentionConfig", c.TicketMentionConfig, &c.mentions))
+
+	var err error
+	c.teamOptions, err = parseOptionsSetting("TeamOptionsConfig", c.TeamOptionsConfig, teamOptions)
+	report(err)
+	c.projectOptions, err = parseOptionsSetting("ProjectOptionsConfig", c.ProjectOptionsConfig, projectOptions)
+	report(err)
+
+	report(c.parsePrefixes())
+	report(c.parseWorkflow())
+	report(c.parseSLATargets())
+
+	for _, name := range splitList(c.SLAEscalationUsers) {
+		c.escalationUsers = append(c.escalationUsers, strings.TrimPrefix(name, "@"))
+	}
+
+	report(c.parseWebhookSubscriptions())
+
+	c.customFields, err = parseCustomFields(c.CustomFieldsConfig)
+	report(err)
+	c.ticketTypes, err = parseTicketTypes(c.TicketTypesConfig, c.customFields)
+	report(err)
+	c.channelProfiles, err = parseChannelProfiles(c.ChannelProfilesConfig)
+	report(err)
+	c.transitionPolicy, err = parseTransitionPolicy(c.TransitionPermissionsConfig)
+	report(err)
+	report(c.parseReactionActions())
+
+	c.templates, err = parseMessageTemplates(map[string]string{
+		templateTicketPost: c.TicketPostTemplate,
+		templateResolved:   c.ResolvedReplyTemplate,
+		templateReopened:   c.ReopenedReplyTemplate,
+		templateAttachment: c.AttachmentTextTemplate,
+	})
+	report(err)
+
+	// Incoming webhooks may refer to ticket types, so they are parsed last
+	report(c.parseIncomingWebhooks())
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// isEnabled reads an on/off switch. Switches default to on so that settings
+// added after an install do not turn features off on upgrade.
+func isEnabled(setting *bool) bool {
+	return setting == nil || *setting
+}
+
+// parsePrefixes reads the channel name to ticket prefix map
+func (c *configuration) parsePrefixes() error {
+	var prefixes map[string]string
+	if err := parseJSONSetting("TicketPrefixConfig", c.TicketPrefixConfig, &prefixes); err != nil {
+		return err
+	}
+
+	c.prefixes = make(map[string]string, len(prefixes))
+	for channel, prefix := range prefixes {
+		prefix = strings.ToUpper(strings.TrimSpace(prefix))
+		if !ticketPrefixPattern.MatchString(prefix) {
+			return errors.Errorf("TicketPrefixConfig: prefix %q for channel %q must be 1-10 letters or digits starting with a letter", prefix, channel)
+		}
+		c.prefixes[channel] = prefix
+	}
+	return nil
+}
+
+// parseReactionActions reads the emoji to ticket action map
+func (c *configuration) parseReactionActions() error {
+	var actions map[string]string
+	if err := parseJSONSetting("ReactionActionsConfig", c.ReactionActionsConfig, &actions); err != nil {
+		return err
+	}
+	var err error
+	c.reactionActions, err = parseReactionActions("ReactionActionsConfig", actions)
+	return err
+}
+
+// parseWorkflow reads the custom ticket workflow, if any
+func (c *configuration) parseWorkflow() error {
+	c.workflow = defaultWorkflow
+	if strings.TrimSpace(c.TicketWorkflowConfig) == "" {
+		return nil
+	}
+
+	var workflow Workflow
+	if err := parseJSONSetting("TicketWorkflowConfig", c.TicketWorkflowConfig, &workflow); err != nil {
+		return err
+	}
+	if err := workflow.validate(); err != nil {
+		return errors.Wrap(err, "TicketWorkflowConfig")
+	}
+	c.workflow = &workflow
+	return nil
+}
+
+// parseSLATargets reads the SLA targets keyed by "priority/environment"
+func (c *configuration) parseSLATargets() error {
+	var targets map[string]*SLATarget
+	if err := parseJSONSetting("SLAConfig", c.SLAConfig, &targets); err != nil {
+		return err
+	}
+
+	c.slaTargets = make(map[string]*SLATarget, len(targets))
+	for key, target := range targets {
+		if target == nil {
+			continue
+		}
+		if !strings.Contains(key, "/") {
+			return errors.Errorf("SLAConfig: key %q must have the form \"priority/environment\"", key)
+		}
+		if err := target.parse(); err != nil {
+			return errors.Wrapf(err, "SLAConfig: %q", key)
+		}
+		c.slaTargets[key] = target
+	}
+	return nil
+}
+
+// parseWebhookSubscriptions reads the outbound webhook subscriptions
+func (c *configuration) parseWebhookSubscriptions() error {
+	var subscriptions []*WebhookSubscription
+	if err := parseJSONSetting("WebhookSubscriptionsConfig", c.WebhookSubscriptionsConfig, &subscriptions); err != nil {
+		return err
+	}
+
+	c.webhookSubscriptions = nil
+	ids := make(map[string]bool, len(subscriptions))
+	for i, subscription := range subscriptions {
+		if subscription == nil {
+			continue
+		}
+		u, err := url.Parse(subscription.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.Errorf("WebhookSubscriptionsConfig: entry %d has an invalid url %q, expected an http(s) URL", i+1, subscription.URL)
+		}
+		subscription.ID = strings.TrimSpace(subscription.ID)
+		if subscription.ID == "" {
+			subscription.ID = subscription.defaultID()
+		}
+		if ids[subscription.ID] {
+			return errors.Errorf("WebhookSubscriptionsConfig: entry %d has the same id as another subscription, or the same url, events and secret", i+1)
+		}
+		ids[subscription.ID] = true
+		c.webhookSubscriptions = append(c.webhookSubscriptions, subscription)
+	}
+	return nil
+}
+
+// parseIncomingWebhooks reads the inbound webhooks keyed by their ID
+func (c *configuration) parseIncomingWebhooks() error {
+	var hooks []*IncomingWebhook
+	if err := parseJSONSetting("IncomingWebhooksConfig", c.IncomingWebhooksConfig, &hooks); err != nil {
+		return err
+	}
+
+	c.incomingWebhooks = make(map[string]*IncomingWebhook, len(hooks))
+	for i, hook := range hooks {
+		if hook == nil {
+			continue
+		}
+		if hook.ID == "" || hook.Token == "" || hook.ChannelID == "" {
+			return errors.Errorf("IncomingWebhooksConfig: entry %d needs an id, token and channel_id", i+1)
+		}
+		if hook.Alertmanager != nil && hook.Alertmanager.Type != "" && !c.hasTicketType(hook.Alertmanager.Type) {
+			return errors.Errorf("IncomingWebhooksConfig: hook %q uses unknown ticket type %q", hook.ID, hook.Alertmanager.Type)
+		}
+		if _, exists := c.incomingWebhooks[hook.ID]; exists {
+			return errors.Errorf("IncomingWebhooksConfig: duplicate id %q", hook.ID)
+		}
+		c.incomingWebhooks[hook.ID] = hook
+	}
+	return nil
+}
+
+// hasTicketType reports whether a ticket type with the ID is configured
+func (c *configuration) hasTicketType(typeID string) bool {
+	for _, ticketType := range c.ticketTypes {
+		if ticketType.ID == typeID {
+			return true
+		}
+	}
+	return false
+}
+
+// parseJSONSetting unmarshals a JSON setting into v. Empty settings are left unset.
+func parseJSONSetting(name, raw string, v interface{}) error {
+	if strings.TrimSpace(raw) == "" {
+		return nil
+	}
+	if err := json.Unmarshal([]byte(raw), v); err != nil {
+		return errors.Wrapf(err, "%s is not valid JSON", name)
+	}
+	return nil
+}
+
+// parseOptionsSetting reads a list of {"Text": ..., "Value": ...} dropdown
+// options, returning the defaults when the setting is empty
+func parseOptionsSetting(name, raw string, defaults []*model.PostActionOptions) ([]*model.PostActionOptions, error) {
+	var parsed []struct {
+		Text  string `json:"Text"`
+		Value string `json:"Value"`
+	}
+	if err := parseJSONSetting(name, raw, &parsed); err != nil {
+		return defaults, err
+	}
+
+	var options []*model.PostActionOptions
+	for i, o := range parsed {
+		if o.Text == "" || o.Value == "" {
+			return defaults, errors.Errorf("%s: entry %d needs both Text and Value", name, i+1)
+		}
+		options = append(options, &model.PostActionOptions{Text: o.Text, Value: o.Value})
+	}
+	if len(options) == 0 {
+		return defaults, nil
+	}
+	return options, nil
+}
+
+// getConfiguration returns the active configuration. The returned value must
+// not be modified.
+func (p *Plugin) getConfiguration() *configuration {
+	p.configurationLock.RLock()
+	defer p.configurationLock.RUnlock()
+
+	if p.configuration == nil {
+		return emptyConfiguration
+	}
+	return p.configuration
+}
+
+// hasConfiguration reports whether a valid configuration has been loaded
+func (p *Plugin) hasConfiguration() bool {
+	p.configurationLock.RLock()
+	defer p.configurationLock.RUnlock()
+
+	return p.configuration != nil
+}
+
+// setConfiguration replaces the active configuration
+func (p *Plugin) setConfiguration(configuration *configuration) {
+	p.configurationLock.Lock()
+	defer p.configurationLock.Unlock()
+
+	p.configuration = configuration
+}
+
+// emptyConfiguration is used until a configuration has been loaded
+var emptyConfiguration = func() *configuration {
+	c := &configuration{}
+	if err := c.parse(); err != nil {
+		panic(err)
+	}
+	return c
+}()
+
+// OnConfigurationChange is invoked when the configuration changes. An invalid
+// configuration is rejected and the previous one stays active; without a
+// previous one the plugin does not activate.
+func (p *Plugin) OnConfigurationChange() error {
+	configuration := new(configuration)
+	if err := p.API.LoadPluginConfiguration(configuration); err != nil {
+		return errors.Wrap(err, "failed to load plugin configuration")
+	}
+
+	if err := configuration.parse(); err != nil {
+		p.API.LogError("Invalid plugin configuration, keeping the previous one", "error", err.Error())
+		return errors.Wrap(err, "invalid plugin configuration")
+	}
+
+	p.setConfiguration(configuration)
+
+	// The command is registered in OnActivate; refresh it once the plugin is running
+	if p.botUserID != "" {
+		if err := p.registerTicketCommand(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ConfigurationWillBeSaved rejects plugin settings that do not parse, so the
+// System Console shows the problem instead of the change being ignored. Only
+// Mattermost 8.0 and later call it; older servers rely on
+// OnConfigurationChange keeping the previous configuration.
+func (p *Plugin) ConfigurationWillBeSaved(newCfg *model.Config) (*model.Config, error) {
+	settings, ok := newCfg.PluginSettings.Plugins[pluginID]
+	if !ok {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read ticket plugin settings")
+	}
+	configuration := new(configuration)
+	if err := json.Unmarshal(data, configuration); err != nil {
+		return nil, errors.Wrap(err, "failed to read ticket plugin settings")
+	}
+
+	if err := configuration.parse(); err != nil {
+		return nil, errors.Wrap(err, "invalid ticket plugin settings")
+	}
+	return nil, nil
+}
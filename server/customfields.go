@@ -0,0 +1,337 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+// Custom field types
+const (
+	CustomFieldText     = "text"
+	CustomFieldTextarea = "textarea"
+	CustomFieldSelect   = "select"
+	CustomFieldBool     = "bool"
+	CustomFieldUser     = "user"
+	CustomFieldChannel  = "channel"
+)
+
+const (
+	// customFieldElementPrefix namespaces custom fields in the dialog
+	// submission so they never collide with the built-in elements
+	customFieldElementPrefix = "field_"
+
+	// maxTextFieldLength and maxTextareaFieldLength are the dialog limits
+	// for text and textarea elements
+	maxTextFieldLength     = 150
+	maxTextareaFieldLength = 3000
+)
+
+// customFieldNamePattern restricts field names to short snake_case identifiers
+var customFieldNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,29}$`)
+
+// CustomField is an admin defined extra field of the ticket dialog. Values
+// are stored on the ticket as strings keyed by Name; bool fields hold
+// "true" or "false" and user and channel fields hold IDs. A required bool
+// field must be ticked.
+type CustomField struct {
+	Name        string                     `json:"name"`
+	DisplayName string                     `json:"display_name"`
+	Type        string                     `json:"type"`
+	Required    bool                       `json:"required"`
+	MaxLength   int                        `json:"max_length"`
+	Default     string                     `json:"default"`
+	Placeholder string                     `json:"placeholder"`
+	HelpText    string                     `json:"help_text"`
+	Options     []*model.PostActionOptions `json:"options"`
+}
+
+// validate checks the field definition
+func (f *CustomField) validate() error {
+	if !customFieldNamePattern.MatchString(f.Name) {
+		return errors.Errorf("field name %q must be lower-case letters, digits and underscores", f.Name)
+	}
+	if f.DisplayName == "" {
+		return errors.Errorf("field %q needs a display_name", f.Name)
+	}
+
+	limit := 0
+	switch f.Type {
+	case CustomFieldText:
+		limit = maxTextFieldLength
+	case CustomFieldTextarea:
+		limit = maxTextareaFieldLength
+	case CustomFieldSelect:
+		if len(f.Options) == 0 {
+			return errors.Errorf("select field %q needs options", f.Name)
+		}
+		for _, option := range f.Options {
+			if option == nil || option.Text == "" || option.Value == "" {
+				return errors.Errorf("select field %q has an option without text or value", f.Name)
+			}
+		}
+	case CustomFieldBool, CustomFieldUser, CustomFieldChannel:
+	default:
+		return errors.Errorf("field %q has unknown type %q, expected one of text, textarea, select, bool, user or channel", f.Name, f.Type)
+	}
+
+	if limit > 0 {
+		if f.MaxLength < 0 || f.MaxLength > limit {
+			return errors.Errorf("field %q max_length must be between 1 and %d", f.Name, limit)
+		}
+		if f.MaxLength == 0 {
+			f.MaxLength = limit
+		}
+	}
+
+	if f.Default != "" {
+		if reason := f.checkValue(f.Default); reason != "" {
+			return errors.Errorf("field %q default: %s", f.Name, reason)
+		}
+	}
+	return nil
+}
+
+// checkValue validates the shape of a non-empty value. Users and channels
+// are checked against the server by validateCustomFields.
+func (f *CustomField) checkValue(value string) string {
+	switch f.Type {
+	case CustomFieldText, CustomFieldTextarea:
+		if len([]rune(value)) > f.MaxLength {
+			return fmt.Sprintf("must be at most %d characters", f.MaxLength)
+		}
+	case CustomFieldSelect:
+		if !hasOption(f.Options, value) {
+			return fmt.Sprintf("unknown option %q", value)
+		}
+	case CustomFieldBool:
+		if value != "true" && value != "false" {
+			return "must be true or false"
+		}
+	case CustomFieldUser, CustomFieldChannel:
+		if !model.IsValidId(value) {
+			return "must be an ID"
+		}
+	}
+	return ""
+}
+
+// dialogElement returns the dialog element for the field
+func (f *CustomField) dialogElement() model.DialogElement {
+	element := model.DialogElement{
+		DisplayName: f.DisplayName,
+		Name:        customFieldElementPrefix + f.Name,
+		Type:        f.Type,
+		Default:     f.Default,
+		Placeholder: f.Placeholder,
+		HelpText:    f.HelpText,
+		Optional:    !f.Required,
+		MaxLength:   f.MaxLength,
+		Options:     f.Options,
+	}
+
+	switch f.Type {
+	case CustomFieldUser:
+		element.Type = "select"
+		element.DataSource = "users"
+	case CustomFieldChannel:
+		element.Type = "select"
+		element.DataSource = "channels"
+	}
+	return element
+}
+
+// parseCustomFields validates the `CustomFieldsConfig` setting
+func parseCustomFields(raw string) ([]*CustomField, error) {
+	var fields []*CustomField
+	if err := parseJSONSetting("CustomFieldsConfig", raw, &fields); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var valid []*CustomField
+	for _, field := range fields {
+		if field == nil {
+			continue
+		}
+		if err := field.validate(); err != nil {
+			return nil, errors.Wrap(err, "CustomFieldsConfig")
+		}
+		if seen[field.Name] {
+			return nil, errors.Errorf("CustomFieldsConfig: duplicate field %q", field.Name)
+		}
+		seen[field.Name] = true
+		valid = append(valid, field)
+	}
+	return valid, nil
+}
+
+// getCustomFields returns the admin defined fields of the ticket type in
+// dialog order: the global fields followed by the type's own fields
+func (p *Plugin) getCustomFields(ticketType string) []*CustomField {
+	fields := p.getConfiguration().customFields
+	if t := p.getTicketType(ticketType); t != nil && len(t.Fields) > 0 {
+		fields = append(append([]*CustomField{}, fields...), t.Fields...)
+	}
+	return fields
+}
+
+// customFieldsFromSubmission reads the custom field values of a dialog submission
+func customFieldsFromSubmission(fields []*CustomField, submission map[string]interface{}) map[string]string {
+	values := make(map[string]string)
+	for _, field := range fields {
+		switch value := submission[customFieldElementPrefix+field.Name].(type) {
+		case string:
+			if value = strings.TrimSpace(value); value != "" {
+				values[field.Name] = value
+			}
+		case bool:
+			values[field.Name] = fmt.Sprintf("%t", value)
+		}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	return values
+}
+
+// withCustomFieldDefaults returns the values with the defaults of missing fields filled in
+func withCustomFieldDefaults(fields []*CustomField, values map[string]string) map[string]string {
+	merged := make(map[string]string, len(values))
+	for name, value := range values {
+		merged[name] = value
+	}
+	for _, field := range fields {
+		if _, ok := merged[field.Name]; !ok && field.Default != "" {
+			merged[field.Name] = field.Default
+		}
+	}
+	if len(merged) == 0 {
+		return nil
+	}
+	return merged
+}
+
+// validateCustomFields checks the values against the field definitions and
+// returns the problems keyed by field name
+func (p *Plugin) validateCustomFields(fields []*CustomField, values map[string]string) map[string]string {
+	problems := make(map[string]string)
+	known := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		known[field.Name] = true
+
+		// A required bool field is a checkbox that must be ticked
+		value := values[field.Name]
+		if field.Required && (value == "" || (field.Type == CustomFieldBool && value == "false")) {
+			problems[field.Name] = "This field is required."
+			continue
+		}
+		if value == "" {
+			continue
+		}
+
+		if reason := field.checkValue(value); reason != "" {
+			problems[field.Name] = reason
+			continue
+		}
+		switch field.Type {
+		case CustomFieldUser:
+			if _, appErr := p.API.GetUser(value); appErr != nil {
+				problems[field.Name] = "unknown user"
+			}
+		case CustomFieldChannel:
+			if _, appErr := p.API.GetChannel(value); appErr != nil {
+				problems[field.Name] = "unknown channel"
+			}
+		}
+	}
+
+	for name := range values {
+		if !known[name] {
+			problems[name] = "unknown field"
+		}
+	}
+	return problems
+}
+
+// describeFieldProblems joins field problems into a single user facing reason
+func describeFieldProblems(problems map[string]string) string {
+	names := make([]string, 0, len(problems))
+	for name := range problems {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	reasons := make([]string, 0, len(names))
+	for _, name := range names {
+		reasons = append(reasons, fmt.Sprintf("field %q: %s", name, problems[name]))
+	}
+	return strings.Join(reasons, "; ")
+}
+
+// renderedField is a custom field value formatted for display
+type renderedField struct {
+	Name  string
+	Label string
+	Value string
+}
+
+// renderCustomFields returns the ticket's custom field values for display:
+// configured fields in their configured order, then values of fields that
+// have since been removed from the configuration
+func (p *Plugin) renderCustomFields(ticket *Ticket) []renderedField {
+	if len(ticket.Fields) == 0 {
+		return nil
+	}
+
+	var fields []renderedField
+	rendered := make(map[string]bool)
+	for _, field := range p.getCustomFields(ticket.Type) {
+		value, ok := ticket.Fields[field.Name]
+		if !ok || value == "" {
+			continue
+		}
+		rendered[field.Name] = true
+		fields = append(fields, renderedField{Name: field.Name, Label: field.DisplayName, Value: p.formatCustomFieldValue(field, value)})
+	}
+
+	var leftover []string
+	for name, value := range ticket.Fields {
+		if !rendered[name] && value != "" {
+			leftover = append(leftover, name)
+		}
+	}
+	sort.Strings(leftover)
+	for _, name := range leftover {
+		fields = append(fields, renderedField{Name: name, Label: name, Value: ticket.Fields[name]})
+	}
+	return fields
+}
+
+// formatCustomFieldValue renders a stored value for display
+func (p *Plugin) formatCustomFieldValue(field *CustomField, value string) string {
+	switch field.Type {
+	case CustomFieldBool:
+		if value == "true" {
+			return "Yes"
+		}
+		return "No"
+	case CustomFieldSelect:
+		for _, option := range field.Options {
+			if option.Value == value {
+				return option.Text
+			}
+		}
+	case CustomFieldUser:
+		return "@" + p.getUsername(value)
+	case CustomFieldChannel:
+		if name := p.getChannelName(value); name != "" {
+			return "~" + name
+		}
+	}
+	return value
+}
@@ -12,44 +12,52 @@ import (
 
 // ServeHTTP handles HTTP requests
 func (p *Plugin) ServeHTTP(c *plugin.Context, w http.ResponseWriter, r *http.Request) {
-	p.API.LogInfo("ServeHTTP request received", "path", r.URL.Path, "method", r.Method, "raw_query", r.URL.RawQuery, "host", r.Host, "remote_addr", r.RemoteAddr)
-
-	if r.URL.Path == "/api/v1/dialog" {
-		p.handleDialogSubmit(w, r)
-		return
-	}
-
-	if r.URL.Path == "/api/v1/runresolve" || strings.Contains(r.URL.Path, "/runresolve") {
-		p.API.LogInfo("Button integration path matched", "path", r.URL.Path)
-		p.handleRunResolve(w, r)
-		return
-	}
-
-	if r.URL.Path == "/api/v1/runreopen" || strings.Contains(r.URL.Path, "/runreopen") {
-		p.API.LogInfo("Reopen button integration path matched", "path", r.URL.Path)
-		p.handleRunReopen(w, r)
-		return
-	}
+	p.router.ServeHTTP(w, r)
+}
 
-	if !strings.Contains(r.URL.Path, "/api/v1/") {
-		p.API.LogWarn("Unhandled path in ServeHTTP", "path", r.URL.Path)
-	}
-	http.NotFound(w, r)
+// newRouter maps the plugin's routes to their handlers. Paths are matched
+// exactly; everything else is answered with 404.
+func (p *Plugin) newRouter() *http.ServeMux {
+	router := http.NewServeMux()
+
+	router.HandleFunc(apiTicketsPath, p.handleTicketsAPI)
+	router.HandleFunc(apiTicketsPath+"/", p.handleTicketsAPI)
+	router.HandleFunc(incomingPathPrefix+"{id}", p.handleIncomingWebhook)
+	router.HandleFunc(incomingPathPrefix+"{id}"+alertmanagerPathSuffix, p.handleAlertmanagerWebhook)
+
+	router.HandleFunc("GET /api/v1/audit", p.handleAuditExport)
+
+	router.HandleFunc("POST /api/v1/dialog", p.handleDialogSubmit)
+	router.HandleFunc("POST /api/v1/runresolve", p.handleRunResolve)
+	router.HandleFunc("POST /api/v1/runreopen", p.handleRunReopen)
+	router.HandleFunc("POST /api/v1/transition", p.handleRunTransition)
+	router.HandleFunc("POST /api/v1/claim", p.handleRunClaim)
+	router.HandleFunc("POST /api/v1/reassign", p.handleRunReassign)
+	router.HandleFunc("POST /api/v1/reassign/submit", p.handleReassignSubmit)
+	router.HandleFunc("POST /api/v1/edit", p.handleRunEdit)
+	router.HandleFunc("POST /api/v1/edit/submit", p.handleEditSubmit)
+
+	return router
 }
 
 // handleDialogSubmit processes the ticket creation dialog submission
 func (p *Plugin) handleDialogSubmit(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
 	var request model.SubmitDialogRequest
 	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
+	if !authenticatedUser(r, request.UserId) {
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+
+	if request.Cancelled {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
 	var ticketData TicketDialog
 	if teamNameVal, ok := request.Submission["team_name"].(string); ok {
 		ticketData.TeamName = teamNameVal
@@ -66,7 +74,11 @@ func (p *Plugin) handleDialogSubmit(w http.ResponseWriter, r *http.Request) {
 	if descriptionVal, ok := request.Submission["description"].(string); ok {
 		ticketData.Description = descriptionVal
 	}
-	ticketData.Summary = request.Submission["summary"].(string)
+	if summaryVal, ok := request.Submission["summary"].(string); ok {
+		ticketData.Summary = summaryVal
+	}
+	ticketData.Type = request.State
+	ticketData.Fields = customFieldsFromSubmission(p.getCustomFields(ticketData.Type), request.Submission)
 
 	if ticketData.TeamName == "" || ticketData.ProjectName == "" || ticketData.Environment == "" || ticketData.Description == "" {
 		w.Header().Set("Content-Type", "application/json")
@@ -92,8 +104,35 @@ func (p *Plugin) handleDialogSubmit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if ticketData.Type != "" && p.getTicketType(ticketData.Type) == nil {
+		p.writeDialogError(w, "This ticket type is no longer available.", nil)
+		return
+	}
+	if problems := p.validateCustomFields(p.getCustomFields(ticketData.Type), ticketData.Fields); len(problems) > 0 {
+		fieldErrors := make(map[string]string, len(problems))
+		for name, problem := range problems {
+			fieldErrors[customFieldElementPrefix+name] = problem
+		}
+		p.writeDialogError(w, "", fieldErrors)
+		return
+	}
+
+	// Stale or forged submissions may carry values that are not among the
+	// current options of the channel
+	p.applyTicketDefaults(&ticketData, request.ChannelId)
+	if reason := p.validateTicketFields(ticketData, request.ChannelId); reason != "" {
+		p.writeDialogError(w, reason, nil)
+		return
+	}
+
+	// Creation may have been switched off while the dialog was open
+	if reason := p.ticketCreationDisabled(request.ChannelId); reason != "" {
+		p.writeDialogError(w, reason, nil)
+		return
+	}
+
 	// Create ticket
-	if err := p.createTicket(ticketData, request.ChannelId, request.UserId); err != nil {
+	if _, err := p.createTicket(ticketData, request.ChannelId, request.UserId, SourceDialog); err != nil {
 		http.Error(w, "Failed to create ticket", http.StatusInternalServerError)
 		return
 	}
@@ -105,125 +144,311 @@ func (p *Plugin) handleDialogSubmit(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// handleRunResolve executes /resolve command when button is clicked
+// handleRunResolve resolves the ticket when a button rendered before
+// configurable workflows is clicked
 func (p *Plugin) handleRunResolve(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		p.API.LogError("Invalid method for runresolve", "method", r.Method)
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	req, ticket := p.decodeActionRequest(w, r)
+	if ticket == nil {
+		return
+	}
+
+	p.runTransition(w, req, ticket, TicketStatusResolved)
+}
+
+// handleRunReopen executes reopen action when a button rendered before
+// configurable workflows is clicked
+func (p *Plugin) handleRunReopen(w http.ResponseWriter, r *http.Request) {
+	req, ticket := p.decodeActionRequest(w, r)
+	if ticket == nil {
+		return
+	}
+
+	p.runTransition(w, req, ticket, TicketStatusOpen)
+}
+
+// handleRunTransition moves a ticket to the status of the clicked workflow button
+func (p *Plugin) handleRunTransition(w http.ResponseWriter, r *http.Request) {
+	req, ticket := p.decodeActionRequest(w, r)
+	if ticket == nil {
+		return
+	}
+
+	to, ok := req.Context["to"].(string)
+	if !ok || to == "" {
+		p.API.LogError("Missing or invalid target status in context")
+		http.Error(w, "Missing target status", http.StatusBadRequest)
+		return
+	}
+
+	p.runTransition(w, req, ticket, to)
+}
+
+// runTransition validates a button driven status change against the workflow and applies it
+func (p *Plugin) runTransition(w http.ResponseWriter, req *model.PostActionIntegrationRequest, ticket *Ticket, to string) {
+	workflow := p.getWorkflow(ticket.Type)
+	if !workflow.CanTransition(ticket.Status, to) {
+		p.writeIntegrationResponse(w, fmt.Sprintf("This ticket cannot move from **%s** to **%s**.", workflow.StatusName(ticket.Status), workflow.StatusName(to)))
+		return
+	}
+
+	if reason := p.transitionDisabled(workflow, ticket.Status, to); reason != "" {
+		p.writeIntegrationResponse(w, reason)
+		return
+	}
+
+	if reason := p.transitionDenied(ticket, workflow, ticket.Status, to, req.UserId); reason != "" {
+		p.writeIntegrationResponse(w, reason)
+		return
+	}
+
+	if err := p.transitionTicket(ticket, to, req.UserId, SourceButton); err != nil {
+		p.API.LogError("Failed to transition ticket", "error", err.Error(), "ticket", ticket.Key(), "to", to)
+		http.Error(w, "Failed to update ticket", http.StatusInternalServerError)
+		return
+	}
+
+	p.writeIntegrationResponse(w, fmt.Sprintf("Ticket %s moved to **%s**.", ticket.Key(), workflow.StatusName(to)))
+}
+
+// handleRunClaim assigns the ticket to the user who clicked the Claim button
+func (p *Plugin) handleRunClaim(w http.ResponseWriter, r *http.Request) {
+	req, ticket := p.decodeActionRequest(w, r)
+	if ticket == nil {
+		return
+	}
+
+	if p.getWorkflow(ticket.Type).IsDone(ticket.Status) {
+		p.writeIntegrationResponse(w, fmt.Sprintf("Ticket %s is already resolved.", ticket.Key()))
 		return
 	}
 
-	var req model.PostActionIntegrationRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		p.API.LogError("Failed to decode run resolve request", "error", err.Error())
+	if ticket.AssigneeID != "" {
+		p.writeIntegrationResponse(w, fmt.Sprintf("Ticket %s is already assigned to @%s.", ticket.Key(), p.getUsername(ticket.AssigneeID)))
+		return
+	}
+
+	if reason := p.checkAssignee(ticket, req.UserId); reason != "" {
+		p.writeIntegrationResponse(w, reason)
+		return
+	}
+
+	if err := p.assignTicket(ticket, req.UserId, req.UserId, SourceButton); err != nil {
+		p.API.LogError("Failed to claim ticket", "error", err.Error(), "ticket", ticket.Key())
+		http.Error(w, "Failed to update ticket", http.StatusInternalServerError)
+		return
+	}
+
+	p.writeIntegrationResponse(w, fmt.Sprintf("You claimed ticket %s.", ticket.Key()))
+}
+
+// handleRunReassign opens the reassign dialog when the Reassign button is clicked
+func (p *Plugin) handleRunReassign(w http.ResponseWriter, r *http.Request) {
+	req, ticket := p.decodeActionRequest(w, r)
+	if ticket == nil {
+		return
+	}
+
+	dialog := model.OpenDialogRequest{
+		TriggerId: req.TriggerId,
+		URL:       fmt.Sprintf("/plugins/%s/api/v1/reassign/submit", pluginID),
+		Dialog: model.Dialog{
+			Title: "Reassign " + ticket.Key(),
+			Elements: []model.DialogElement{
+				{
+					DisplayName: "Assignee",
+					Name:        "assignee",
+					Type:        "select",
+					DataSource:  "users",
+					Placeholder: "Select a user",
+					Default:     ticket.AssigneeID,
+				},
+			},
+			SubmitLabel: "Reassign",
+			State:       ticket.Key(),
+		},
+	}
+
+	if err := p.API.OpenInteractiveDialog(dialog); err != nil {
+		p.API.LogError("Failed to open reassign dialog", "error", err.Error())
+		p.writeIntegrationResponse(w, "Failed to open reassign dialog: "+err.Error())
+		return
+	}
+
+	p.writeIntegrationResponse(w, "")
+}
+
+// handleReassignSubmit processes the reassign dialog submission
+func (p *Plugin) handleReassignSubmit(w http.ResponseWriter, r *http.Request) {
+	var request model.SubmitDialogRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	postID, ok := req.Context["post_id"].(string)
-	if !ok || postID == "" {
-		p.API.LogError("Missing or invalid post_id in context")
-		http.Error(w, "Missing post_id", http.StatusBadRequest)
+	if !authenticatedUser(r, request.UserId) {
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
 		return
 	}
 
-	channelID, ok := req.Context["channel_id"].(string)
-	if !ok || channelID == "" {
-		p.API.LogError("Missing or invalid channel_id in context")
-		http.Error(w, "Missing channel_id", http.StatusBadRequest)
+	if request.Cancelled {
+		w.WriteHeader(http.StatusOK)
 		return
 	}
 
-	args := &model.CommandArgs{
-		UserId:    req.UserId,
-		ChannelId: channelID,
-		Command:   fmt.Sprintf("/resolve %s", postID),
+	ticket, err := p.findTicket(request.State)
+	if err != nil {
+		p.API.LogError("Failed to get ticket for reassign", "error", err.Error())
+		http.Error(w, "Failed to get ticket", http.StatusInternalServerError)
+		return
 	}
 
-	resp, _ := p.handleResolveCommand(nil, args)
+	if ticket == nil {
+		p.writeDialogError(w, "This ticket no longer exists.", nil)
+		return
+	}
 
-	integrationResp := &model.PostActionIntegrationResponse{
-		EphemeralText: resp.Text,
+	if reason := p.checkTicketAccess(ticket, request.UserId); reason != "" {
+		p.writeDialogError(w, reason, nil)
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(integrationResp); err != nil {
-		p.API.LogError("failed to encode integration response", "error", err.Error())
+	if p.getWorkflow(ticket.Type).IsDone(ticket.Status) {
+		p.writeDialogError(w, fmt.Sprintf("Ticket %s is already resolved.", ticket.Key()), nil)
+		return
+	}
+
+	assigneeID, _ := request.Submission["assignee"].(string)
+	if assigneeID == "" {
+		p.writeDialogError(w, "", map[string]string{"assignee": "Please select a user."})
+		return
 	}
+
+	if reason := p.checkAssignee(ticket, assigneeID); reason != "" {
+		p.writeDialogError(w, "", map[string]string{"assignee": reason})
+		return
+	}
+
+	if err := p.assignTicket(ticket, assigneeID, request.UserId, SourceDialog); err != nil {
+		p.API.LogError("Failed to reassign ticket", "error", err.Error(), "ticket", ticket.Key())
+		http.Error(w, "Failed to update ticket", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
 }
 
-// handleRunReopen executes reopen action when button is clicked
-func (p *Plugin) handleRunReopen(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		p.API.LogError("Invalid method for runreopen", "method", r.Method)
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+// handleRunEdit opens the edit dialog when the Edit button is clicked
+func (p *Plugin) handleRunEdit(w http.ResponseWriter, r *http.Request) {
+	req, ticket := p.decodeActionRequest(w, r)
+	if ticket == nil {
 		return
 	}
 
-	var req model.PostActionIntegrationRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		p.API.LogError("Failed to decode run reopen request", "error", err.Error())
+	if err := p.openEditTicketDialog(req.TriggerId, ticket); err != nil {
+		p.API.LogError("Failed to open edit dialog", "error", err.Error())
+		p.writeIntegrationResponse(w, "Failed to open edit dialog: "+err.Error())
+		return
+	}
+
+	p.writeIntegrationResponse(w, "")
+}
+
+// handleEditSubmit processes the edit dialog submission
+func (p *Plugin) handleEditSubmit(w http.ResponseWriter, r *http.Request) {
+	var request model.SubmitDialogRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	postID, ok := req.Context["post_id"].(string)
-	if !ok || postID == "" {
-		p.API.LogError("Missing or invalid post_id in context")
-		http.Error(w, "Missing post_id", http.StatusBadRequest)
+	if !authenticatedUser(r, request.UserId) {
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
 		return
 	}
 
-	channelID, ok := req.Context["channel_id"].(string)
-	if !ok || channelID == "" {
-		p.API.LogError("Missing or invalid channel_id in context")
-		http.Error(w, "Missing channel_id", http.StatusBadRequest)
+	if request.Cancelled {
+		w.WriteHeader(http.StatusOK)
 		return
 	}
 
-	post, err := p.API.GetPost(postID)
+	ticket, err := p.findTicket(request.State)
 	if err != nil {
-		p.API.LogError("Failed to get post for reopen", "error", err.Error())
-		http.Error(w, "Failed to get post", http.StatusInternalServerError)
+		p.API.LogError("Failed to get ticket for edit", "error", err.Error())
+		http.Error(w, "Failed to get ticket", http.StatusInternalServerError)
 		return
 	}
 
-	if !strings.Contains(post.Message, "✅ **Status:** Resolved") {
-		integrationResp := &model.PostActionIntegrationResponse{
-			EphemeralText: "This ticket is not resolved.",
-		}
-		w.Header().Set("Content-Type", "application/json")
-		if err := json.NewEncoder(w).Encode(integrationResp); err != nil {
-			p.API.LogError("failed to encode integration response", "error", err.Error())
-		}
+	if ticket == nil || !p.API.HasPermissionToChannel(request.UserId, ticket.ChannelID, model.PermissionReadChannel) {
+		p.writeDialogError(w, "This ticket no longer exists.", nil)
 		return
 	}
 
-	// Update post to open status
-	updatePost := post.Clone()
-	updatePost.Message = strings.Replace(updatePost.Message, "✅ **Status:** Resolved", "**Status:** Open", 1)
-	p.attachResolveButton(updatePost, postID, channelID)
+	if reason := p.checkTicketAccess(ticket, request.UserId); reason != "" {
+		p.writeDialogError(w, reason, nil)
+		return
+	}
 
-	if _, err := p.API.UpdatePost(updatePost); err != nil {
-		p.API.LogError("Failed to update post for reopen", "error", err.Error())
-		http.Error(w, "Failed to update post", http.StatusInternalServerError)
+	patch := ticketPatchFromSubmission(ticket, p.getCustomFields(ticket.Type), request.Submission)
+	if reason := p.validateTicketPatch(ticket, patch); reason != "" {
+		p.writeDialogError(w, reason, nil)
 		return
 	}
 
-	replyPost := &model.Post{
-		ChannelId: channelID,
-		UserId:    req.UserId,
-		Message:   "🔄 Reopened",
-		Type:      model.PostTypeDefault,
-		RootId:    postID,
+	if err := p.updateTicketFields(ticket, patch, request.UserId, SourceDialog); err != nil {
+		p.API.LogError("Failed to edit ticket", "error", err.Error(), "ticket", ticket.Key())
+		http.Error(w, "Failed to update ticket", http.StatusInternalServerError)
+		return
 	}
 
-	if _, err := p.API.CreatePost(replyPost); err != nil {
-		p.API.LogError("Failed to create reopen reply", "error", err.Error())
+	w.WriteHeader(http.StatusOK)
+}
+
+// ticketPatchFromSubmission reads an edit dialog submission into a patch.
+// Custom fields emptied in the dialog are cleared on the ticket.
+func ticketPatchFromSubmission(ticket *Ticket, fields []*CustomField, submission map[string]interface{}) *TicketPatch {
+	text := func(name string) *string {
+		value, _ := submission[name].(string)
+		value = strings.TrimSpace(value)
+		return &value
+	}
+
+	patch := &TicketPatch{
+		TeamName:    text("team_name"),
+		ProjectName: text("project_name"),
+		Environment: text("environment"),
+		Priority:    text("priority"),
+		Summary:     text("summary"),
+		Description: text("description"),
+		Fields:      customFieldsFromSubmission(fields, submission),
+	}
+	for _, field := range fields {
+		if _, ok := patch.Fields[field.Name]; !ok && ticket.Fields[field.Name] != "" {
+			if patch.Fields == nil {
+				patch.Fields = make(map[string]string)
+			}
+			patch.Fields[field.Name] = ""
+		}
+	}
+	return patch
+}
+
+// writeDialogError rejects a dialog submission, keeping the dialog open
+func (p *Plugin) writeDialogError(w http.ResponseWriter, message string, fieldErrors map[string]string) {
+	resp := &model.SubmitDialogResponse{
+		Error:  message,
+		Errors: fieldErrors,
 	}
 
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		p.API.LogError("failed to encode dialog response", "error", err.Error())
+	}
+}
+
+// writeIntegrationResponse replies to a button click with an ephemeral message
+func (p *Plugin) writeIntegrationResponse(w http.ResponseWriter, text string) {
 	integrationResp := &model.PostActionIntegrationResponse{
-		EphemeralText: "Ticket reopened successfully!",
+		EphemeralText: text,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
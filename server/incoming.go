@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// incomingPathPrefix is the route prefix of inbound ticket webhooks
+const incomingPathPrefix = "/api/v1/incoming/"
+
+// IncomingWebhook is an admin configured endpoint that lets external systems
+// create tickets in a channel
+type IncomingWebhook struct {
+	ID           string               `json:"id"`
+	Token        string               `json:"token"`
+	ChannelID    string               `json:"channel_id"`
+	Alertmanager *AlertmanagerMapping `json:"alertmanager,omitempty"`
+}
+
+// handleIncomingWebhook creates a ticket from an external JSON payload:
+//
+//	POST /api/v1/incoming/{hookID}
+//	Authorization: Bearer <token>
+//
+// The body uses the ticket dialog fields (team_name, project_name,
+// environment, priority, summary, description). The ticket is posted by the
+// plugin's bot in the hook's channel.
+func (p *Plugin) handleIncomingWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		p.writeAPIError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	hook := p.authenticateIncomingWebhook(w, r, strings.TrimPrefix(r.URL.Path, incomingPathPrefix))
+	if hook == nil {
+		return
+	}
+	if reason := p.ticketCreationDisabled(hook.ChannelID); reason != "" {
+		p.writeAPIError(w, http.StatusForbidden, reason)
+		return
+	}
+
+	var ticketData TicketDialog
+	if err := json.NewDecoder(r.Body).Decode(&ticketData); err != nil {
+		p.writeAPIError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	p.applyTicketDefaults(&ticketData, hook.ChannelID)
+	if reason := p.validateTicketFields(ticketData, hook.ChannelID); reason != "" {
+		p.writeAPIError(w, http.StatusBadRequest, reason)
+		return
+	}
+
+	ticket, err := p.createTicket(ticketData, hook.ChannelID, p.botUserID, SourceWebhook)
+	if err != nil {
+		p.API.LogError("Failed to create ticket from incoming webhook", "error", err.Error(), "hook_id", hook.ID)
+		p.writeAPIError(w, http.StatusInternalServerError, "Failed to create ticket")
+		return
+	}
+
+	p.writeJSON(w, http.StatusCreated, ticket)
+}
+
+// authenticateIncomingWebhook returns the configured hook matching the ID
+// and the request's token while external intake is enabled. It writes the
+// error response and returns nil otherwise.
+func (p *Plugin) authenticateIncomingWebhook(w http.ResponseWriter, r *http.Request, hookID string) *IncomingWebhook {
+	hook := p.getIncomingWebhook(hookID)
+
+	// The token is only read from the header; query strings end up in logs
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	// Unknown hooks and bad tokens get the same answer so hook IDs cannot be probed
+	if hook == nil || token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(hook.Token)) != 1 {
+		p.writeAPIError(w, http.StatusUnauthorized, "Invalid webhook or token")
+		return nil
+	}
+
+	if !p.externalIntakeEnabled() {
+		p.writeAPIError(w, http.StatusServiceUnavailable, "External ticket intake is currently disabled")
+		return nil
+	}
+
+	return hook
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+// Markers of ticket posts written by versions without ticket records, whose
+// state lives only in the post message
+const (
+	legacyTicketMarker   = "🎫 **New Ticket Created**"
+	legacyResolvedMarker = "✅ **Status:** Resolved"
+	legacyNoSummary      = "No summary provided"
+)
+
+// legacyCutoffKey stores when ticket records were first enabled. Only posts
+// created before then can be legacy ticket posts.
+const legacyCutoffKey = "legacycutoff"
+
+var (
+	legacyFieldPattern   = regexp.MustCompile(`• (Team|Project|Environment|Summary): \*\*(.*?)\*\*`)
+	legacyMentionPattern = regexp.MustCompile(`@([a-z0-9._-]+)`)
+)
+
+// ensureLegacyCutoff records the upgrade time on the first activation with
+// ticket records and loads it
+func (p *Plugin) ensureLegacyCutoff() error {
+	cutoff, err := p.ensureActivationTime(legacyCutoffKey)
+	if err != nil {
+		return err
+	}
+	p.legacyCutoff = cutoff
+	return nil
+}
+
+// isLegacyTicketPost reports whether the post is a ticket post from before
+// tickets were stored as records. Posts written after the upgrade are never
+// legacy ticket posts, whatever their text.
+func (p *Plugin) isLegacyTicketPost(post *model.Post) bool {
+	return post.CreateAt < p.legacyCutoff && post.RootId == "" && strings.Contains(post.Message, legacyTicketMarker) && post.GetProp(ticketIDProp) == nil
+}
+
+// getOrImportTicketByPostID loads the ticket rendered in the post like
+// getTicketByPostID, importing the post first when it is a legacy ticket post
+func (p *Plugin) getOrImportTicketByPostID(postID string) (*Ticket, error) {
+	ticket, err := p.getTicketByPostID(postID)
+	if err != nil || ticket != nil {
+		return ticket, err
+	}
+
+	post, appErr := p.API.GetPost(postID)
+	if appErr != nil {
+		return nil, nil
+	}
+	return p.importLegacyTicket(post)
+}
+
+// importLegacyTicket builds a ticket record from a legacy ticket post, gives
+// it a ticket number and re-renders the post from the record. It returns nil
+// when the post is not a legacy ticket post or could not be created as a
+// ticket today.
+func (p *Plugin) importLegacyTicket(post *model.Post) (*Ticket, error) {
+	if !p.isLegacyTicketPost(post) {
+		return nil, nil
+	}
+
+	ticket := legacyTicketFromPost(post)
+	if reason := p.legacyTicketRejected(ticket); reason != "" {
+		p.API.LogWarn("Ignoring legacy ticket post", "post_id", post.Id, "reason", reason)
+		return nil, nil
+	}
+
+	// Claim the post index first so concurrent imports of the same post
+	// create a single ticket
+	ticketID := model.NewId()
+	claimed, appErr := p.API.KVSetWithOptions(ticketPostKey(post.Id), []byte(ticketID), model.PluginKVSetOptions{Atomic: true, OldValue: nil})
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to claim legacy ticket post")
+	}
+	if !claimed {
+		return p.getTicketByPostID(post.Id)
+	}
+
+	ticket.ID = ticketID
+	ticket.Prefix = p.getTicketPrefix(post.ChannelId)
+	ticket.Description = p.legacyTicketDescription(post)
+
+	number, err := p.nextTicketNumber(ticket.Prefix)
+	if err != nil {
+		p.API.KVDelete(ticketPostKey(post.Id))
+		return nil, err
+	}
+	ticket.Number = number
+
+	if err := p.saveTicket(ticket); err != nil {
+		p.API.KVDelete(ticketPostKey(post.Id))
+		return nil, err
+	}
+	p.API.LogInfo("Imported legacy ticket post", "ticket", ticket.Key(), "post_id", post.Id)
+	p.recordTicketEvent(EventTicketCreated, ticket, ticket.ReporterID, SourceLegacy, creationChanges(ticket))
+
+	if err := p.updateTicketPost(ticket); err != nil {
+		p.API.LogError("Failed to re-render imported ticket post", "error", err.Error(), "ticket", ticket.Key())
+	}
+	return ticket, nil
+}
+
+// legacyTicketRejected returns why the ticket read from a legacy post cannot
+// be imported, or an empty string. The post is held to the same channel,
+// kill switch and option checks as a new ticket.
+func (p *Plugin) legacyTicketRejected(ticket *Ticket) string {
+	if !p.validateChannel(ticket.ChannelID) {
+		return "tickets are not allowed in the channel"
+	}
+	if reason := p.ticketCreationDisabled(ticket.ChannelID); reason != "" {
+		return reason
+	}
+	if !hasOption(p.getTeamOptions(ticket.ChannelID), ticket.TeamName) {
+		return fmt.Sprintf("unknown team %q", ticket.TeamName)
+	}
+	if !hasOption(p.getProjectOptions(ticket.ChannelID), ticket.ProjectName) {
+		return fmt.Sprintf("unknown project %q", ticket.ProjectName)
+	}
+	if !hasOption(environmentOptions, ticket.Environment) {
+		return fmt.Sprintf("unknown environment %q", ticket.Environment)
+	}
+	if !hasOption(priorityOptions, ticket.Priority) {
+		return fmt.Sprintf("unknown priority %q", ticket.Priority)
+	}
+	return ""
+}
+
+// legacyTicketFromPost reads the ticket fields from a legacy ticket post
+func legacyTicketFromPost(post *model.Post) *Ticket {
+	ticket := &Ticket{
+		PostID:     post.Id,
+		ChannelID:  post.ChannelId,
+		ReporterID: post.UserId,
+		Priority:   "standard",
+		Status:     TicketStatusOpen,
+		CreatedAt:  post.CreateAt,
+		UpdatedAt:  post.UpdateAt,
+	}
+
+	for _, match := range legacyFieldPattern.FindAllStringSubmatch(post.Message, -1) {
+		switch match[1] {
+		case "Team":
+			ticket.TeamName = match[2]
+		case "Project":
+			ticket.ProjectName = match[2]
+		case "Environment":
+			ticket.Environment = match[2]
+		case "Summary":
+			if match[2] != legacyNoSummary {
+				ticket.Summary = match[2]
+			}
+		}
+	}
+
+	if priority := post.GetPriority(); priority != nil && priority.Priority != nil && *priority.Priority != "" {
+		ticket.Priority = *priority.Priority
+	}
+
+	if strings.Contains(post.Message, legacyResolvedMarker) {
+		ticket.Status = TicketStatusResolved
+		ticket.ResolvedAt = post.UpdateAt
+	}
+
+	// Mentions were appended after the status line
+	if idx := strings.Index(post.Message, "**Status:**"); idx >= 0 {
+		for _, match := range legacyMentionPattern.FindAllStringSubmatch(post.Message[idx:], -1) {
+			ticket.Mentions = append(ticket.Mentions, match[1])
+		}
+	}
+
+	return ticket
+}
+
+// legacyTicketDescription returns the description of a legacy ticket, which
+// was posted by the reporter as the first reply in the ticket thread
+func (p *Plugin) legacyTicketDescription(post *model.Post) string {
+	thread, appErr := p.API.GetPostThread(post.Id)
+	if appErr != nil {
+		p.API.LogWarn("Failed to get legacy ticket thread", "error", appErr.Error(), "post_id", post.Id)
+		return ""
+	}
+
+	var first *model.Post
+	for _, reply := range thread.Posts {
+		if reply.RootId != post.Id || reply.UserId != post.UserId {
+			continue
+		}
+		if first == nil || reply.CreateAt < first.CreateAt {
+			first = reply
+		}
+	}
+	if first == nil {
+		return ""
+	}
+	return first.Message
+}
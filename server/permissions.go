@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+// Roles that can be granted the resolve and reopen actions. Members of a
+// Mattermost group are granted with "group:<name>".
+const (
+	RoleAnyone       = "anyone"
+	RoleReporter     = "reporter"
+	RoleAssignee     = "assignee"
+	RoleChannelAdmin = "channel_admin"
+	RoleSystemAdmin  = "system_admin"
+
+	groupRolePrefix = "group:"
+)
+
+// Ticket actions covered by transition policies
+const (
+	ActionResolve = "resolve"
+	ActionReopen  = "reopen"
+)
+
+// TransitionPolicy lists the roles allowed to resolve and reopen tickets. An
+// action without roles is open to everyone who can see the ticket.
+type TransitionPolicy struct {
+	Resolve []string `json:"resolve"`
+	Reopen  []string `json:"reopen"`
+}
+
+// validate checks the roles of both actions
+func (t *TransitionPolicy) validate() error {
+	for _, action := range []string{ActionResolve, ActionReopen} {
+		roles := t.roles(action)
+		for i, role := range roles {
+			role = strings.ToLower(strings.TrimSpace(role))
+			switch {
+			case role == RoleAnyone, role == RoleReporter, role == RoleAssignee, role == RoleChannelAdmin, role == RoleSystemAdmin:
+			case strings.HasPrefix(role, groupRolePrefix) && len(role) > len(groupRolePrefix):
+				role = groupRolePrefix + strings.TrimPrefix(role[len(groupRolePrefix):], "@")
+			default:
+				return errors.Errorf("%s has unknown role %q, expected anyone, reporter, assignee, channel_admin, system_admin or group:<name>", action, role)
+			}
+			roles[i] = role
+		}
+	}
+	return nil
+}
+
+// roles returns the roles allowed to perform the action
+func (t *TransitionPolicy) roles(action string) []string {
+	if action == ActionReopen {
+		return t.Reopen
+	}
+	return t.Resolve
+}
+
+// parseTransitionPolicy validates the `TransitionPermissionsConfig` setting
+func parseTransitionPolicy(raw string) (*TransitionPolicy, error) {
+	policy := &TransitionPolicy{}
+	if err := parseJSONSetting("TransitionPermissionsConfig", raw, policy); err != nil {
+		return nil, err
+	}
+	if err := policy.validate(); err != nil {
+		return nil, errors.Wrap(err, "TransitionPermissionsConfig")
+	}
+	return policy, nil
+}
+
+// transitionAction returns the policy action of a status change, or an empty
+// string when the change is neither resolving nor reopening
+func transitionAction(workflow *Workflow, from, to string) string {
+	fromDone, toDone := workflow.IsDone(from), workflow.IsDone(to)
+	switch {
+	case !fromDone && toDone:
+		return ActionResolve
+	case fromDone && !toDone:
+		return ActionReopen
+	}
+	return ""
+}
+
+// transitionRoles returns the roles allowed to perform the action on tickets
+// of the channel. A channel profile's policy replaces the global one per action.
+func (p *Plugin) transitionRoles(channelId, action string) []string {
+	if profile := p.getChannelProfile(channelId); profile != nil && profile.Permissions != nil {
+		if roles := profile.Permissions.roles(action); len(roles) > 0 {
+			return roles
+		}
+	}
+	if policy := p.getConfiguration().transitionPolicy; policy != nil {
+		return policy.roles(action)
+	}
+	return nil
+}
+
+// transitionDenied returns why the user may not move the ticket from one
+// status to another, or an empty string when the policy allows it. Changes
+// made by the plugin's bot, e.g. for Alertmanager, are always allowed.
+func (p *Plugin) transitionDenied(ticket *Ticket, workflow *Workflow, from, to, userID string) string {
+	action := transitionAction(workflow, from, to)
+	if action == "" || userID == p.botUserID {
+		return ""
+	}
+
+	roles := p.transitionRoles(ticket.ChannelID, action)
+	if len(roles) == 0 {
+		return ""
+	}
+	for _, role := range roles {
+		if p.hasTicketRole(ticket, userID, role) {
+			return ""
+		}
+	}
+
+	return fmt.Sprintf("You are not allowed to %s %s. It can be done by %s.", action, ticket.Key(), describeRoles(roles))
+}
+
+// hasTicketRole reports whether the user holds the role for the ticket
+func (p *Plugin) hasTicketRole(ticket *Ticket, userID, role string) bool {
+	switch role {
+	case RoleAnyone:
+		return true
+	case RoleReporter:
+		return ticket.ReporterID == userID
+	case RoleAssignee:
+		return ticket.AssigneeID != "" && ticket.AssigneeID == userID
+	case RoleChannelAdmin:
+		member, appErr := p.API.GetChannelMember(ticket.ChannelID, userID)
+		if appErr != nil {
+			return false
+		}
+		return member.SchemeAdmin || strings.Contains(" "+member.Roles+" ", " "+model.ChannelAdminRoleId+" ")
+	case RoleSystemAdmin:
+		return p.API.HasPermissionTo(userID, model.PermissionManageSystem)
+	}
+
+	name := strings.TrimPrefix(role, groupRolePrefix)
+	groups, appErr := p.API.GetGroupsForUser(userID)
+	if appErr != nil {
+		p.API.LogError("Failed to get groups for user", "error", appErr.Error(), "user_id", userID)
+		return false
+	}
+	for _, group := range groups {
+		if group.GetName() == name || group.Id == name {
+			return true
+		}
+	}
+	return false
+}
+
+// describeRoles lists roles for a denial message
+func describeRoles(roles []string) string {
+	names := make([]string, 0, len(roles))
+	for _, role := range roles {
+		switch role {
+		case RoleAnyone:
+			names = append(names, "anyone")
+		case RoleReporter:
+			names = append(names, "the reporter")
+		case RoleAssignee:
+			names = append(names, "the assignee")
+		case RoleChannelAdmin:
+			names = append(names, "channel admins")
+		case RoleSystemAdmin:
+			names = append(names, "system admins")
+		default:
+			names = append(names, "members of @"+strings.TrimPrefix(role, groupRolePrefix))
+		}
+	}
+	if len(names) == 1 {
+		return names[0]
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " or " + names[len(names)-1]
+}
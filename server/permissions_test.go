@@ -0,0 +1,244 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransitionDenied(t *testing.T) {
+	const (
+		reporterID = "reporterid"
+		assigneeID = "assigneeid"
+		userID     = "userid"
+	)
+
+	notMember := model.NewAppError("GetChannelMember", "not_found", nil, "", http.StatusNotFound)
+	group := func(name string) *model.Group {
+		return &model.Group{Id: model.NewId(), Name: model.NewPointer(name)}
+	}
+
+	for name, tc := range map[string]struct {
+		permissions string
+		profiles    string
+		assigneeID  string
+		from, to    string
+		userID      string
+		setup       func(api *plugintest.API, ticket *Ticket)
+		allowed     bool
+	}{
+		"no policy": {
+			userID:  userID,
+			allowed: true,
+		},
+		"action left out of the policy": {
+			permissions: `{"reopen": ["system_admin"]}`,
+			userID:      userID,
+			allowed:     true,
+		},
+		"status change that is not resolving or reopening": {
+			permissions: `{"resolve": ["system_admin"], "reopen": ["system_admin"]}`,
+			from:        TicketStatusOpen,
+			to:          TicketStatusOpen,
+			userID:      userID,
+			allowed:     true,
+		},
+		"bot is exempt": {
+			permissions: `{"resolve": ["system_admin"]}`,
+			userID:      "botuserid",
+			allowed:     true,
+		},
+		"anyone": {
+			permissions: `{"resolve": ["anyone"]}`,
+			userID:      userID,
+			allowed:     true,
+		},
+		"reporter as reporter": {
+			permissions: `{"resolve": ["reporter"]}`,
+			userID:      reporterID,
+			allowed:     true,
+		},
+		"reporter as someone else": {
+			permissions: `{"resolve": ["reporter"]}`,
+			userID:      userID,
+		},
+		"assignee as assignee": {
+			permissions: `{"resolve": ["assignee"]}`,
+			assigneeID:  assigneeID,
+			userID:      assigneeID,
+			allowed:     true,
+		},
+		"assignee as someone else": {
+			permissions: `{"resolve": ["assignee"]}`,
+			assigneeID:  assigneeID,
+			userID:      userID,
+		},
+		"assignee on an unassigned ticket": {
+			permissions: `{"resolve": ["assignee"]}`,
+			userID:      userID,
+		},
+		"channel admin by scheme": {
+			permissions: `{"resolve": ["channel_admin"]}`,
+			userID:      userID,
+			setup: func(api *plugintest.API, ticket *Ticket) {
+				api.On("GetChannelMember", ticket.ChannelID, userID).Return(&model.ChannelMember{SchemeAdmin: true}, nil)
+			},
+			allowed: true,
+		},
+		"channel admin by role": {
+			permissions: `{"resolve": ["channel_admin"]}`,
+			userID:      userID,
+			setup: func(api *plugintest.API, ticket *Ticket) {
+				api.On("GetChannelMember", ticket.ChannelID, userID).Return(&model.ChannelMember{Roles: "channel_user channel_admin"}, nil)
+			},
+			allowed: true,
+		},
+		"channel admin as plain member": {
+			permissions: `{"resolve": ["channel_admin"]}`,
+			userID:      userID,
+			setup: func(api *plugintest.API, ticket *Ticket) {
+				api.On("GetChannelMember", ticket.ChannelID, userID).Return(&model.ChannelMember{SchemeUser: true, Roles: "channel_user"}, nil)
+			},
+		},
+		"channel admin as non-member": {
+			permissions: `{"resolve": ["channel_admin"]}`,
+			userID:      userID,
+			setup: func(api *plugintest.API, ticket *Ticket) {
+				api.On("GetChannelMember", ticket.ChannelID, userID).Return(nil, notMember)
+			},
+		},
+		"system admin": {
+			permissions: `{"resolve": ["system_admin"]}`,
+			userID:      userID,
+			setup: func(api *plugintest.API, ticket *Ticket) {
+				api.On("HasPermissionTo", userID, model.PermissionManageSystem).Return(true)
+			},
+			allowed: true,
+		},
+		"system admin as regular user": {
+			permissions: `{"resolve": ["system_admin"]}`,
+			userID:      userID,
+			setup: func(api *plugintest.API, ticket *Ticket) {
+				api.On("HasPermissionTo", userID, model.PermissionManageSystem).Return(false)
+			},
+		},
+		"group member": {
+			permissions: `{"resolve": ["group:@SRE"]}`,
+			userID:      userID,
+			setup: func(api *plugintest.API, ticket *Ticket) {
+				api.On("GetGroupsForUser", userID).Return([]*model.Group{group("qa"), group("sre")}, nil)
+			},
+			allowed: true,
+		},
+		"group non-member": {
+			permissions: `{"resolve": ["group:sre"]}`,
+			userID:      userID,
+			setup: func(api *plugintest.API, ticket *Ticket) {
+				api.On("GetGroupsForUser", userID).Return([]*model.Group{group("qa")}, nil)
+			},
+		},
+		"group lookup failure": {
+			permissions: `{"resolve": ["group:sre"]}`,
+			userID:      userID,
+			setup: func(api *plugintest.API, ticket *Ticket) {
+				api.On("GetGroupsForUser", userID).Return(nil, model.NewAppError("GetGroupsForUser", "error", nil, "", http.StatusInternalServerError))
+			},
+		},
+		"any of several roles": {
+			permissions: `{"resolve": ["assignee", "reporter"]}`,
+			userID:      reporterID,
+			allowed:     true,
+		},
+		"reopen policy": {
+			permissions: `{"resolve": ["anyone"], "reopen": ["reporter"]}`,
+			from:        TicketStatusResolved,
+			to:          TicketStatusOpen,
+			userID:      userID,
+		},
+		"reopen policy as reporter": {
+			permissions: `{"resolve": ["anyone"], "reopen": ["reporter"]}`,
+			from:        TicketStatusResolved,
+			to:          TicketStatusOpen,
+			userID:      reporterID,
+			allowed:     true,
+		},
+		"channel profile overrides the global policy": {
+			permissions: `{"resolve": ["assignee"]}`,
+			profiles:    `{"ticketchannelid": {"permissions": {"resolve": ["reporter"]}}}`,
+			userID:      reporterID,
+			allowed:     true,
+		},
+		"channel profile restricts an open global policy": {
+			profiles: `{"ticketchannelid": {"permissions": {"resolve": ["reporter"]}}}`,
+			userID:   userID,
+		},
+		"channel profile without the action falls back to the global policy": {
+			permissions: `{"resolve": ["reporter"]}`,
+			profiles:    `{"ticketchannelid": {"permissions": {"reopen": ["anyone"]}}}`,
+			userID:      userID,
+		},
+		"channel profile keyed by channel name": {
+			permissions: `{"resolve": ["system_admin"]}`,
+			profiles:    `{"~Tickets": {"permissions": {"resolve": ["anyone"]}}}`,
+			userID:      userID,
+			setup: func(api *plugintest.API, ticket *Ticket) {
+				api.On("GetChannel", ticket.ChannelID).Return(&model.Channel{Id: ticket.ChannelID, Name: "tickets"}, nil)
+			},
+			allowed: true,
+		},
+		"channel profile of another channel": {
+			permissions: `{"resolve": ["reporter"]}`,
+			profiles:    `{"otherchannelid": {"permissions": {"resolve": ["anyone"]}}}`,
+			userID:      userID,
+			setup: func(api *plugintest.API, ticket *Ticket) {
+				api.On("GetChannel", ticket.ChannelID).Return(&model.Channel{Id: ticket.ChannelID, Name: "tickets"}, nil)
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			p, api := newTestPlugin(t, &configuration{
+				TransitionPermissionsConfig: tc.permissions,
+				ChannelProfilesConfig:       tc.profiles,
+			})
+			ticket := testTicket(api)
+			ticket.AssigneeID = tc.assigneeID
+			if tc.setup != nil {
+				tc.setup(api, ticket)
+			}
+
+			from, to := tc.from, tc.to
+			if from == "" {
+				from, to = TicketStatusOpen, TicketStatusResolved
+			}
+
+			reason := p.transitionDenied(ticket, defaultWorkflow, from, to, tc.userID)
+			if tc.allowed {
+				assert.Empty(t, reason)
+			} else {
+				assert.Contains(t, reason, "You are not allowed to")
+			}
+		})
+	}
+}
+
+func TestParseTransitionPolicy(t *testing.T) {
+	policy, err := parseTransitionPolicy(`{"resolve": [" Reporter ", "group:@SRE"], "reopen": ["system_admin"]}`)
+	require.NoError(t, err)
+	assert.Equal(t, []string{RoleReporter, "group:sre"}, policy.Resolve)
+	assert.Equal(t, []string{RoleSystemAdmin}, policy.Reopen)
+
+	for name, raw := range map[string]string{
+		"unknown role":     `{"resolve": ["owner"]}`,
+		"empty group name": `{"reopen": ["group:"]}`,
+		"invalid JSON":     `{"resolve": "reporter"}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := parseTransitionPolicy(raw)
+			assert.Error(t, err)
+		})
+	}
+}
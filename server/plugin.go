@@ -1,28 +1,85 @@
 package main
 
 import (
+	"net/http"
 	"strings"
+	"sync"
 
 	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/mattermost/mattermost/server/public/plugin"
+	"github.com/mattermost/mattermost/server/public/pluginapi/cluster"
 	"github.com/pkg/errors"
 )
 
 type Plugin struct {
 	plugin.MattermostPlugin
+
+	// configurationLock synchronizes access to the configuration
+	configurationLock sync.RWMutex
+
+	// configuration is the active plugin configuration. Consult getConfiguration
+	// and setConfiguration for usage.
+	configuration *configuration
+
+	// botUserID is the user ID of the plugin's bot account
+	botUserID string
+
+	// actionSecret signs the integration context of ticket buttons
+	actionSecret []byte
+
+	// legacyCutoff is when ticket records were first enabled, in Unix
+	// milliseconds. Only older posts are imported as legacy tickets.
+	legacyCutoff int64
+
+	// auditIndexSince is when audit indexes were introduced, in Unix
+	// milliseconds. The audit index of an older ticket is built by a scan the
+	// first time it is needed.
+	auditIndexSince int64
+
+	// router maps HTTP requests to their handlers
+	router *http.ServeMux
+
+	// slaJob periodically checks ticket SLAs on a single node of the cluster
+	slaJob *cluster.Job
+
+	// webhookJob delivers queued outbound webhooks on a single node of the cluster
+	webhookJob *cluster.Job
 }
 
 // OnActivate is called when the plugin is activated
 func (p *Plugin) OnActivate() error {
-	if err := p.API.RegisterCommand(&model.Command{
-		Trigger:          "ticket",
-		DisplayName:      "Create Ticket",
-		Description:      "Create a new ticket",
-		AutoComplete:     true,
-		AutoCompleteDesc: "Create a new ticket",
-		AutoCompleteHint: "",
-	}); err != nil {
-		return errors.Wrap(err, "failed to register command")
+	// Running on the defaults would lift the channel restrictions, kill
+	// switches and permissions, so an invalid stored configuration stops
+	// activation until it is fixed
+	if !p.hasConfiguration() {
+		if err := p.OnConfigurationChange(); err != nil {
+			return err
+		}
+	}
+
+	botUserID, err := p.API.EnsureBotUser(&model.Bot{
+		Username:    "ticket",
+		DisplayName: "Ticket",
+		Description: "Created by the Ticket plugin.",
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to ensure bot user")
+	}
+	p.botUserID = botUserID
+
+	if err := p.ensureActionSecret(); err != nil {
+		return err
+	}
+	if err := p.ensureLegacyCutoff(); err != nil {
+		return err
+	}
+	if err := p.ensureAuditIndexSince(); err != nil {
+		return err
+	}
+	p.router = p.newRouter()
+
+	if err := p.registerTicketCommand(); err != nil {
+		return err
 	}
 
 	if err := p.API.RegisterCommand(&model.Command{
@@ -31,11 +88,55 @@ func (p *Plugin) OnActivate() error {
 		Description:      "Mark a ticket as resolved",
 		AutoComplete:     true,
 		AutoCompleteDesc: "Mark a ticket as resolved",
-		AutoCompleteHint: "<post_id>",
+		AutoCompleteHint: "<ticket>",
 	}); err != nil {
 		return errors.Wrap(err, "failed to register resolve command")
 	}
 
+	job, err := cluster.Schedule(p.API, "SLACheckJob", cluster.MakeWaitForRoundedInterval(slaCheckInterval), p.checkSLAs)
+	if err != nil {
+		return errors.Wrap(err, "failed to schedule SLA check job")
+	}
+	p.slaJob = job
+
+	webhookJob, err := cluster.Schedule(p.API, "WebhookDeliveryJob", cluster.MakeWaitForInterval(webhookQueueInterval), p.processWebhookQueue)
+	if err != nil {
+		return errors.Wrap(err, "failed to schedule webhook delivery job")
+	}
+	p.webhookJob = webhookJob
+
+	return nil
+}
+
+// registerTicketCommand registers /ticket. It is registered again whenever
+// the configuration changes so autocomplete lists the current ticket types.
+func (p *Plugin) registerTicketCommand() error {
+	if err := p.API.RegisterCommand(&model.Command{
+		Trigger:          "ticket",
+		DisplayName:      "Create Ticket",
+		Description:      "Create a new ticket",
+		AutoComplete:     true,
+		AutoCompleteDesc: "Create a new ticket",
+		AutoCompleteHint: "",
+		AutocompleteData: getTicketAutocompleteData(p.getTicketTypes()),
+	}); err != nil {
+		return errors.Wrap(err, "failed to register command")
+	}
+	return nil
+}
+
+// OnDeactivate is called when the plugin is deactivated
+func (p *Plugin) OnDeactivate() error {
+	if p.slaJob != nil {
+		if err := p.slaJob.Close(); err != nil {
+			p.API.LogError("Failed to close SLA check job", "error", err.Error())
+		}
+	}
+	if p.webhookJob != nil {
+		if err := p.webhookJob.Close(); err != nil {
+			p.API.LogError("Failed to close webhook delivery job", "error", err.Error())
+		}
+	}
 	return nil
 }
 
@@ -49,3 +150,45 @@ func (p *Plugin) ExecuteCommand(c *plugin.Context, args *model.CommandArgs) (*mo
 
 	return &model.CommandResponse{}, nil
 }
+
+// getTicketAutocompleteData describes the /ticket subcommands and ticket types for autocomplete
+func getTicketAutocompleteData(types []*TicketType) *model.AutocompleteData {
+	ticket := model.NewAutocompleteData("ticket", "", "Create a new ticket")
+
+	for _, ticketType := range types {
+		description := ticketType.Description
+		if description == "" {
+			description = "Create a new " + ticketType.Name
+		}
+		ticket.AddCommand(model.NewAutocompleteData(ticketType.ID, "", description))
+	}
+
+	assign := model.NewAutocompleteData("assign", "<ticket> @user", "Assign a ticket to a user")
+	assign.AddTextArgument("Ticket number, e.g. TCK-142", "<ticket>", "")
+	assign.AddTextArgument("User to assign", "@user", "")
+	ticket.AddCommand(assign)
+
+	edit := model.NewAutocompleteData("edit", "<ticket>", "Edit the details of a ticket")
+	edit.AddTextArgument("Ticket number, e.g. TCK-142", "<ticket>", "")
+	ticket.AddCommand(edit)
+
+	audit := model.NewAutocompleteData("audit", "<ticket>", "Show who changed a ticket and when")
+	audit.AddTextArgument("Ticket number, e.g. TCK-142", "<ticket>", "")
+	ticket.AddCommand(audit)
+
+	list := model.NewAutocompleteData("list", "[--status <status>] [--team <team>] [--env <environment>] [--mine]", "List tickets in this channel")
+	list.AddTextArgument("Filters: --status, --team, --project, --env, --priority, --type, --assignee, --mine, --page", "[filters]", "")
+	ticket.AddCommand(list)
+
+	preview := model.NewAutocompleteData("preview", "[template] [ticket]", "Preview a message template")
+	preview.AddStaticListArgument("Template", false, []model.AutocompleteListItem{
+		{Item: templateTicketPost, HelpText: "The ticket post"},
+		{Item: templateResolved, HelpText: "The reply when a ticket is resolved"},
+		{Item: templateReopened, HelpText: "The reply when a ticket is reopened"},
+		{Item: templateAttachment, HelpText: "The text above the ticket buttons"},
+	})
+	preview.AddTextArgument("Ticket number, e.g. TCK-142; a sample ticket is used when omitted", "[ticket]", "")
+	ticket.AddCommand(preview)
+
+	return ticket
+}
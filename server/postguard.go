@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+)
+
+// ticketPostEditRejected is shown when an edit to a ticket post is refused
+const ticketPostEditRejected = "Ticket posts are generated from the ticket record and cannot be edited. " +
+	"Use the Edit button on the ticket or `/ticket edit` to change its details."
+
+// ticketPostProps are the post props owned by the plugin on a ticket post
+var ticketPostProps = []string{ticketIDProp, ticketKeyProp, "attachments"}
+
+// MessageWillBeUpdated protects ticket posts from manual edits. The ticket post
+// is rendered from the stored ticket, so an edited post would show a state the
+// ticket does not have. Edits that leave the message and the ticket props
+// untouched, such as pinning, are let through. System admins may edit the
+// text; the ticket props and buttons are kept and the next change to the
+// ticket renders the post again.
+func (p *Plugin) MessageWillBeUpdated(c *plugin.Context, newPost, oldPost *model.Post) (*model.Post, string) {
+	// Updates made by the plugin itself carry no session
+	if c == nil || c.SessionId == "" {
+		return newPost, ""
+	}
+	if !ticketPostChanged(oldPost, newPost) {
+		return newPost, ""
+	}
+
+	ticket, err := p.getTicketForPost(oldPost)
+	if err != nil {
+		p.API.LogError("Failed to look up ticket for edited post", "error", err.Error(), "post_id", oldPost.Id)
+		return nil, "The ticket post could not be verified. Please try again."
+	}
+	if ticket == nil {
+		return newPost, ""
+	}
+
+	session, appErr := p.API.GetSession(c.SessionId)
+	if appErr != nil {
+		p.API.LogError("Failed to get session of post edit", "error", appErr.Error(), "post_id", oldPost.Id)
+		return nil, ticketPostEditRejected
+	}
+
+	if p.API.HasPermissionTo(session.UserId, model.PermissionManageSystem) {
+		for _, key := range ticketPostProps {
+			if value := oldPost.GetProp(key); value != nil {
+				newPost.AddProp(key, value)
+			} else {
+				newPost.DelProp(key)
+			}
+		}
+		return newPost, ""
+	}
+
+	p.API.LogInfo("Rejected edit of ticket post", "ticket", ticket.Key(), "user_id", session.UserId)
+	p.API.SendEphemeralPost(session.UserId, &model.Post{
+		UserId:    p.botUserID,
+		ChannelId: oldPost.ChannelId,
+		RootId:    oldPost.Id,
+		Message:   fmt.Sprintf("Your edit to %s was not saved. %s", ticket.Key(), ticketPostEditRejected),
+	})
+	return nil, ticketPostEditRejected
+}
+
+// ticketPostChanged reports whether the update changes the message or the
+// plugin-owned props of the post
+func ticketPostChanged(oldPost, newPost *model.Post) bool {
+	if oldPost.Message != newPost.Message {
+		return true
+	}
+	for _, key := range ticketPostProps {
+		before, _ := json.Marshal(oldPost.GetProp(key))
+		after, _ := json.Marshal(newPost.GetProp(key))
+		if string(before) != string(after) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+	"github.com/pkg/errors"
+)
+
+// Actions that can be bound to emoji reactions on a ticket post
+const (
+	ReactionClaim   = "claim"
+	ReactionResolve = "resolve"
+	ReactionReopen  = "reopen"
+)
+
+// parseReactionActions validates a map of emoji names to reaction actions.
+// Emoji names may be written with or without colons.
+func parseReactionActions(name string, actions map[string]string) (map[string]string, error) {
+	if actions == nil {
+		return nil, nil
+	}
+
+	parsed := make(map[string]string, len(actions))
+	for emoji, action := range actions {
+		emoji = strings.ToLower(strings.Trim(strings.TrimSpace(emoji), ":"))
+		action = strings.ToLower(strings.TrimSpace(action))
+		if emoji == "" {
+			return nil, errors.Errorf("%s: emoji name must not be empty", name)
+		}
+		switch action {
+		case ReactionClaim, ReactionResolve, ReactionReopen:
+		default:
+			return nil, errors.Errorf("%s: emoji %q has unknown action %q, expected claim, resolve or reopen", name, emoji, action)
+		}
+		parsed[emoji] = action
+	}
+	return parsed, nil
+}
+
+// getReactionAction returns the action bound to the emoji in the channel, or
+// an empty string. A channel profile's reactions replace the global ones.
+func (p *Plugin) getReactionAction(channelId, emoji string) string {
+	actions := p.getConfiguration().reactionActions
+	if profile := p.getChannelProfile(channelId); profile != nil && profile.reactions != nil {
+		actions = profile.reactions
+	}
+	return actions[strings.ToLower(emoji)]
+}
+
+// ReactionHasBeenAdded runs the ticket action bound to the emoji when someone
+// reacts to a ticket post. The reaction goes through the same checks as the
+// matching command or button; a refusal is explained to the user in an
+// ephemeral message.
+func (p *Plugin) ReactionHasBeenAdded(c *plugin.Context, reaction *model.Reaction) {
+	if reaction.UserId == p.botUserID {
+		return
+	}
+
+	action := p.getReactionAction(reaction.ChannelId, reaction.EmojiName)
+	if action == "" {
+		return
+	}
+
+	ticket, err := p.getOrImportTicketByPostID(reaction.PostId)
+	if err != nil {
+		p.API.LogError("Failed to get ticket for reaction", "error", err.Error(), "post_id", reaction.PostId)
+		return
+	}
+	if ticket == nil {
+		return
+	}
+
+	reason, err := p.runReactionAction(ticket, action, reaction.UserId)
+	if err != nil {
+		p.API.LogError("Failed to run reaction action", "error", err.Error(), "ticket", ticket.Key(), "action", action)
+		reason = fmt.Sprintf("Failed to %s ticket %s.", action, ticket.Key())
+	}
+	if reason != "" {
+		p.API.SendEphemeralPost(reaction.UserId, &model.Post{
+			UserId:    p.botUserID,
+			ChannelId: ticket.ChannelID,
+			RootId:    ticket.PostID,
+			Message:   fmt.Sprintf("Your :%s: reaction was ignored. %s", reaction.EmojiName, reason),
+		})
+	}
+}
+
+// runReactionAction applies the action for the user and returns why it was
+// refused, or an empty string when it was applied
+func (p *Plugin) runReactionAction(ticket *Ticket, action, userID string) (string, error) {
+	if !p.validateChannel(ticket.ChannelID) {
+		return fmt.Sprintf("Ticket %s cannot be changed in this channel.", ticket.Key()), nil
+	}
+	if reason := p.checkTicketAccess(ticket, userID); reason != "" {
+		return reason, nil
+	}
+
+	workflow := p.getWorkflow(ticket.Type)
+	if action == ReactionClaim {
+		if workflow.IsDone(ticket.Status) {
+			return fmt.Sprintf("Ticket %s is already resolved.", ticket.Key()), nil
+		}
+		if ticket.AssigneeID != "" {
+			return fmt.Sprintf("Ticket %s is already assigned to @%s.", ticket.Key(), p.getUsername(ticket.AssigneeID)), nil
+		}
+		if reason := p.checkAssignee(ticket, userID); reason != "" {
+			return reason, nil
+		}
+		return "", p.assignTicket(ticket, userID, userID, SourceReaction)
+	}
+
+	to := TicketStatusResolved
+	if action == ReactionReopen {
+		to = TicketStatusOpen
+	}
+	if action == ReactionResolve && workflow.IsDone(ticket.Status) {
+		return fmt.Sprintf("Ticket %s is already resolved.", ticket.Key()), nil
+	}
+	if action == ReactionReopen && !workflow.IsDone(ticket.Status) {
+		return fmt.Sprintf("Ticket %s is not resolved.", ticket.Key()), nil
+	}
+	if !workflow.CanTransition(ticket.Status, to) {
+		return fmt.Sprintf("A ticket in status **%s** cannot move to **%s** directly.", workflow.StatusName(ticket.Status), workflow.StatusName(to)), nil
+	}
+	if reason := p.transitionDisabled(workflow, ticket.Status, to); reason != "" {
+		return reason, nil
+	}
+	if reason := p.transitionDenied(ticket, workflow, ticket.Status, to, userID); reason != "" {
+		return reason, nil
+	}
+	return "", p.transitionTicket(ticket, to, userID, SourceReaction)
+}
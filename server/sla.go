@@ -0,0 +1,257 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+// slaWarningRatio is the fraction of an SLA window after which a warning is posted
+const slaWarningRatio = 0.8
+
+// slaCheckInterval is how often the background job evaluates SLAs
+const slaCheckInterval = time.Minute
+
+// slaIndexKey lists the IDs of tickets that may still get an SLA warning or
+// breach, so the SLA job does not load resolved tickets or tickets without SLA
+const slaIndexKey = "slaindex"
+
+// SLATarget holds the response and resolution targets for a priority and environment
+type SLATarget struct {
+	Response   string `json:"response"`
+	Resolution string `json:"resolution"`
+
+	responseDuration   time.Duration
+	resolutionDuration time.Duration
+}
+
+// parse validates the target durations. Either target may be left empty.
+func (t *SLATarget) parse() error {
+	var err error
+	if t.Response != "" {
+		if t.responseDuration, err = time.ParseDuration(t.Response); err != nil {
+			return errors.Wrap(err, "invalid response duration")
+		}
+	}
+	if t.Resolution != "" {
+		if t.resolutionDuration, err = time.ParseDuration(t.Resolution); err != nil {
+			return errors.Wrap(err, "invalid resolution duration")
+		}
+	}
+	return nil
+}
+
+// newTicketSLA computes the SLA deadlines for a ticket created at createdAt
+func newTicketSLA(target *SLATarget, createdAt int64) *TicketSLA {
+	sla := &TicketSLA{}
+	if target.responseDuration > 0 {
+		sla.ResponseDueAt = createdAt + target.responseDuration.Milliseconds()
+	}
+	if target.resolutionDuration > 0 {
+		sla.ResolutionDueAt = createdAt + target.resolutionDuration.Milliseconds()
+	}
+	if sla.ResponseDueAt == 0 && sla.ResolutionDueAt == 0 {
+		return nil
+	}
+	return sla
+}
+
+// slaEvent is a warning or breach raised by evaluateSLA
+type slaEvent struct {
+	breach     bool
+	resolution bool
+	dueAt      int64
+}
+
+// evaluateSLA marks the SLA notifications that are due on the ticket and
+// returns them. Each notification is only raised once.
+func evaluateSLA(ticket *Ticket, done bool, now int64) []slaEvent {
+	sla := ticket.SLA
+	if sla == nil || done {
+		return nil
+	}
+
+	var events []slaEvent
+	check := func(dueAt int64, warned, breached *bool, resolution bool) {
+		if dueAt == 0 || *breached {
+			return
+		}
+		if now >= dueAt {
+			*breached = true
+			*warned = true
+			events = append(events, slaEvent{breach: true, resolution: resolution, dueAt: dueAt})
+			return
+		}
+		warnAt := ticket.CreatedAt + int64(float64(dueAt-ticket.CreatedAt)*slaWarningRatio)
+		if !*warned && now >= warnAt {
+			*warned = true
+			events = append(events, slaEvent{resolution: resolution, dueAt: dueAt})
+		}
+	}
+
+	if !ticketAcknowledged(ticket) {
+		check(sla.ResponseDueAt, &sla.ResponseWarned, &sla.ResponseBreached, false)
+	}
+	check(sla.ResolutionDueAt, &sla.ResolutionWarned, &sla.ResolutionBreached, true)
+
+	return events
+}
+
+// ticketAcknowledged reports whether someone responded to the ticket, by
+// changing its status, assigning it or replying in its thread
+func ticketAcknowledged(ticket *Ticket) bool {
+	return ticket.AcknowledgedAt != 0 || ticket.FirstResponseAt != 0
+}
+
+// slaPending reports whether the ticket may still get an SLA notification
+func slaPending(ticket *Ticket, done bool) bool {
+	sla := ticket.SLA
+	if sla == nil || done {
+		return false
+	}
+	if sla.ResolutionDueAt != 0 && !sla.ResolutionBreached {
+		return true
+	}
+	return sla.ResponseDueAt != 0 && !sla.ResponseBreached && !ticketAcknowledged(ticket)
+}
+
+// trackTicketSLA adds the ticket to the SLA index when it may still get an
+// SLA notification. It is called whenever a ticket is created, reopened or
+// gets new deadlines.
+func (p *Plugin) trackTicketSLA(ticket *Ticket) {
+	if !slaPending(ticket, p.getWorkflow(ticket.Type).IsDone(ticket.Status)) {
+		return
+	}
+	if err := p.addToKeyIndex(slaIndexKey, ticketKeyPrefix, ticket.ID); err != nil {
+		p.API.LogError("Failed to add ticket to the SLA index", "error", err.Error(), "ticket", ticket.Key())
+	}
+}
+
+// untrackTicketSLA removes the ticket from the SLA index. The ticket is
+// checked again afterwards, so a reopen that raced with the removal keeps it
+// in the index.
+func (p *Plugin) untrackTicketSLA(ticketID string) {
+	if err := p.removeFromKeyIndex(slaIndexKey, ticketKeyPrefix, ticketID); err != nil {
+		p.API.LogError("Failed to remove ticket from the SLA index", "error", err.Error(), "ticket_id", ticketID)
+		return
+	}
+
+	ticket, err := p.getTicket(ticketID)
+	if err != nil {
+		p.API.LogError("Failed to load ticket", "error", err.Error(), "ticket_id", ticketID)
+		return
+	}
+	if ticket != nil {
+		p.trackTicketSLA(ticket)
+	}
+}
+
+// checkSLAs is run by the cluster job and posts warnings and escalations for
+// every open ticket whose SLA is close to or past its deadline. Only tickets
+// in the SLA index are loaded.
+func (p *Plugin) checkSLAs() {
+	now := model.GetMillis()
+
+	ids, _, err := p.getKeyIndex(slaIndexKey, ticketKeyPrefix)
+	if err != nil {
+		p.API.LogError("Failed to check ticket SLAs", "error", err.Error())
+		return
+	}
+
+	for _, id := range ids {
+		ticket, err := p.getTicket(id)
+		if err != nil {
+			p.API.LogError("Failed to load ticket", "error", err.Error(), "ticket_id", id)
+			continue
+		}
+		if ticket == nil {
+			p.untrackTicketSLA(id)
+			continue
+		}
+		p.checkTicketSLA(ticket, now)
+	}
+}
+
+// checkTicketSLA posts the SLA notifications that are due on the ticket, and
+// drops it from the SLA index once none can follow
+func (p *Plugin) checkTicketSLA(ticket *Ticket, now int64) {
+	workflow := p.getWorkflow(ticket.Type)
+	if !slaPending(ticket, workflow.IsDone(ticket.Status)) {
+		p.untrackTicketSLA(ticket.ID)
+		return
+	}
+	if len(evaluateSLA(ticket, workflow.IsDone(ticket.Status), now)) == 0 {
+		return
+	}
+
+	// Re-evaluate against the latest copy so concurrent updates are not lost
+	// and every notification is only sent once across the cluster
+	var events []slaEvent
+	updated, err := p.modifyTicket(ticket.ID, func(latest *Ticket) bool {
+		events = evaluateSLA(latest, workflow.IsDone(latest.Status), now)
+		return len(events) > 0
+	})
+	if err != nil {
+		p.API.LogError("Failed to update ticket SLA", "error", err.Error(), "ticket", ticket.Key())
+		return
+	}
+	if updated == nil {
+		return
+	}
+
+	for _, event := range events {
+		if err := p.postTicketReply(updated, p.renderSLAMessage(updated, event, now)); err != nil {
+			p.API.LogError("Failed to post SLA notification", "error", err.Error(), "ticket", updated.Key())
+		}
+	}
+	if !slaPending(updated, workflow.IsDone(updated.Status)) {
+		p.untrackTicketSLA(updated.ID)
+	}
+}
+
+// renderSLAMessage builds the thread reply for an SLA warning or breach
+func (p *Plugin) renderSLAMessage(ticket *Ticket, event slaEvent, now int64) string {
+	what := "acknowledged"
+	window := event.dueAt - ticket.CreatedAt
+	if event.resolution {
+		what = "resolved"
+	}
+
+	var message string
+	if event.breach {
+		message = fmt.Sprintf("🚨 **SLA breached:** %s was not %s within %s.", ticket.Key(), what, formatDuration(window))
+		if escalation := p.getSLAEscalationUsers(); len(escalation) > 0 {
+			message += " Escalating to @" + strings.Join(escalation, " @")
+		}
+	} else {
+		message = fmt.Sprintf("⏰ **SLA warning:** %s has not been %s yet and is due in %s.", ticket.Key(), what, formatDuration(event.dueAt-now))
+	}
+
+	if ticket.AssigneeID != "" {
+		message += " @" + p.getUsername(ticket.AssigneeID)
+	}
+
+	return message
+}
+
+// formatDuration renders milliseconds as a short human-readable duration, e.g. 1h 30m
+func formatDuration(millis int64) string {
+	d := (time.Duration(millis) * time.Millisecond).Round(time.Minute)
+	if d < time.Minute {
+		return "less than a minute"
+	}
+
+	hours := int(d.Hours())
+	minutes := int(d.Minutes()) % 60
+	switch {
+	case hours == 0:
+		return fmt.Sprintf("%dm", minutes)
+	case minutes == 0:
+		return fmt.Sprintf("%dh", hours)
+	default:
+		return fmt.Sprintf("%dh %dm", hours, minutes)
+	}
+}
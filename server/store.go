@@ -0,0 +1,500 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+// KV store key prefixes
+const (
+	ticketKeyPrefix     = "ticket_"
+	ticketPostKeyPrefix = "ticketpost_"
+	ticketRefKeyPrefix  = "ticketref_"
+	ticketSeqKeyPrefix  = "ticketseq_"
+)
+
+// ticketIndexKey lists the IDs of all tickets, so scanning tickets does not
+// walk the whole KV store with its audit entries and webhook deliveries
+const ticketIndexKey = "ticketindex"
+
+// maxSequenceAttempts bounds the compare-and-set retries when reserving a ticket number
+const maxSequenceAttempts = 20
+
+// kvListPageSize is the number of keys fetched per page when scanning the KV store
+const kvListPageSize = 200
+
+func ticketKey(ticketID string) string {
+	return ticketKeyPrefix + ticketID
+}
+
+func ticketPostKey(postID string) string {
+	return ticketPostKeyPrefix + postID
+}
+
+func ticketRefKey(key string) string {
+	return ticketRefKeyPrefix + strings.ToUpper(key)
+}
+
+func ticketSeqKey(prefix string) string {
+	return ticketSeqKeyPrefix + prefix
+}
+
+// saveTicket persists a new ticket record and its indexes. Existing tickets
+// are changed with modifyTicket so concurrent updates are not lost.
+func (p *Plugin) saveTicket(ticket *Ticket) error {
+	data, err := json.Marshal(ticket)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal ticket")
+	}
+
+	if appErr := p.API.KVSet(ticketKey(ticket.ID), data); appErr != nil {
+		return errors.Wrap(appErr, "failed to save ticket")
+	}
+	if err := p.addToKeyIndex(ticketIndexKey, ticketKeyPrefix, ticket.ID); err != nil {
+		return err
+	}
+
+	if ticket.PostID != "" {
+		if appErr := p.API.KVSet(ticketPostKey(ticket.PostID), []byte(ticket.ID)); appErr != nil {
+			return errors.Wrap(appErr, "failed to save ticket post index")
+		}
+	}
+
+	if ticket.Number > 0 {
+		if appErr := p.API.KVSet(ticketRefKey(ticket.Key()), []byte(ticket.ID)); appErr != nil {
+			return errors.Wrap(appErr, "failed to save ticket number index")
+		}
+	}
+
+	return nil
+}
+
+// getTicket loads a ticket by its ID. It returns nil if the ticket does not exist.
+func (p *Plugin) getTicket(ticketID string) (*Ticket, error) {
+	data, appErr := p.API.KVGet(ticketKey(ticketID))
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to get ticket")
+	}
+	if data == nil {
+		return nil, nil
+	}
+
+	var ticket Ticket
+	if err := json.Unmarshal(data, &ticket); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal ticket")
+	}
+	return &ticket, nil
+}
+
+// getTicketByPostID loads the ticket rendered in the given post. It returns nil
+// if the post is not a ticket.
+func (p *Plugin) getTicketByPostID(postID string) (*Ticket, error) {
+	ticketID, appErr := p.API.KVGet(ticketPostKey(postID))
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to get ticket post index")
+	}
+	if ticketID == nil {
+		return nil, nil
+	}
+	return p.getTicket(string(ticketID))
+}
+
+// getTicketForPost loads the ticket rendered in the post, identified by the
+// post's ticket_id prop. Posts rendered before the prop existed are looked up
+// through the post index. It returns nil if the post is not a ticket post.
+func (p *Plugin) getTicketForPost(post *model.Post) (*Ticket, error) {
+	ticketID, _ := post.GetProp(ticketIDProp).(string)
+	if ticketID == "" {
+		return p.getTicketByPostID(post.Id)
+	}
+
+	ticket, err := p.getTicket(ticketID)
+	if err != nil || ticket == nil {
+		return nil, err
+	}
+	// The prop can be copied onto any post, so only the ticket's own post counts
+	if ticket.PostID != post.Id {
+		return nil, nil
+	}
+	return ticket, nil
+}
+
+// errTicketChanged is returned when a ticket changed between being checked
+// and being updated
+var errTicketChanged = errors.New("the ticket was changed by someone else, please try again")
+
+// errTicketDone is returned when assigning a ticket that is in a done status
+var errTicketDone = errors.New("the ticket is already resolved")
+
+// modifyTicket applies fn to the latest stored copy of the ticket and saves it
+// with compare-and-set, retrying if the ticket changed concurrently. fn returns
+// false to leave the ticket untouched. The saved ticket is returned, or nil if
+// the ticket does not exist or fn made no change.
+func (p *Plugin) modifyTicket(ticketID string, fn func(ticket *Ticket) bool) (*Ticket, error) {
+	key := ticketKey(ticketID)
+	for i := 0; i < maxSequenceAttempts; i++ {
+		current, appErr := p.API.KVGet(key)
+		if appErr != nil {
+			return nil, errors.Wrap(appErr, "failed to get ticket")
+		}
+		if current == nil {
+			return nil, nil
+		}
+
+		var ticket Ticket
+		if err := json.Unmarshal(current, &ticket); err != nil {
+			return nil, errors.Wrap(err, "failed to unmarshal ticket")
+		}
+		if !fn(&ticket) {
+			return nil, nil
+		}
+
+		data, err := json.Marshal(&ticket)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to marshal ticket")
+		}
+
+		ok, appErr := p.API.KVCompareAndSet(key, current, data)
+		if appErr != nil {
+			return nil, errors.Wrap(appErr, "failed to save ticket")
+		}
+		if ok {
+			return &ticket, nil
+		}
+	}
+
+	return nil, errors.New("failed to save ticket: too much contention")
+}
+
+// listKeys returns every KV key starting with prefix
+func (p *Plugin) listKeys(prefix string) ([]string, error) {
+	var keys []string
+	for page := 0; ; page++ {
+		pageKeys, appErr := p.API.KVList(page, kvListPageSize)
+		if appErr != nil {
+			return nil, errors.Wrap(appErr, "failed to list keys")
+		}
+
+		for _, key := range pageKeys {
+			if strings.HasPrefix(key, prefix) {
+				keys = append(keys, key)
+			}
+		}
+
+		if len(pageKeys) < kvListPageSize {
+			return keys, nil
+		}
+	}
+}
+
+// getKeyIndex returns the IDs listed under the index key together with the
+// stored value for compare-and-set. An index that does not exist yet, e.g.
+// right after an upgrade, is built once by scanning the keys with the prefix.
+// With an empty prefix a missing index is simply empty.
+func (p *Plugin) getKeyIndex(indexKey, prefix string) ([]string, []byte, error) {
+	for i := 0; i < maxSequenceAttempts; i++ {
+		data, appErr := p.API.KVGet(indexKey)
+		if appErr != nil {
+			return nil, nil, errors.Wrap(appErr, "failed to get key index")
+		}
+		if data != nil {
+			var ids []string
+			if err := json.Unmarshal(data, &ids); err != nil {
+				return nil, nil, errors.Wrap(err, "failed to unmarshal key index")
+			}
+			return ids, data, nil
+		}
+		if prefix == "" {
+			return nil, nil, nil
+		}
+
+		keys, err := p.listKeys(prefix)
+		if err != nil {
+			return nil, nil, err
+		}
+		ids := make([]string, 0, len(keys))
+		for _, key := range keys {
+			ids = append(ids, strings.TrimPrefix(key, prefix))
+		}
+		data, err = json.Marshal(ids)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed to marshal key index")
+		}
+		ok, appErr := p.API.KVCompareAndSet(indexKey, nil, data)
+		if appErr != nil {
+			return nil, nil, errors.Wrap(appErr, "failed to save key index")
+		}
+		if ok {
+			return ids, data, nil
+		}
+	}
+
+	return nil, nil, errors.New("failed to build key index: too much contention")
+}
+
+// updateKeyIndex applies fn to the IDs of the index and saves them with
+// compare-and-set. fn returns false to leave the index untouched.
+func (p *Plugin) updateKeyIndex(indexKey, prefix string, fn func(ids []string) ([]string, bool)) error {
+	for i := 0; i < maxSequenceAttempts; i++ {
+		ids, current, err := p.getKeyIndex(indexKey, prefix)
+		if err != nil {
+			return err
+		}
+		ids, changed := fn(ids)
+		if !changed {
+			return nil
+		}
+
+		data, err := json.Marshal(ids)
+		if err != nil {
+			return errors.Wrap(err, "failed to marshal key index")
+		}
+		ok, appErr := p.API.KVCompareAndSet(indexKey, current, data)
+		if appErr != nil {
+			return errors.Wrap(appErr, "failed to save key index")
+		}
+		if ok {
+			return nil
+		}
+	}
+
+	return errors.New("failed to update key index: too much contention")
+}
+
+// addToKeyIndex adds the ID to the index
+func (p *Plugin) addToKeyIndex(indexKey, prefix, id string) error {
+	return p.updateKeyIndex(indexKey, prefix, func(ids []string) ([]string, bool) {
+		if slices.Contains(ids, id) {
+			return ids, false
+		}
+		return append(ids, id), true
+	})
+}
+
+// removeFromKeyIndex removes the ID from the index
+func (p *Plugin) removeFromKeyIndex(indexKey, prefix, id string) error {
+	return p.updateKeyIndex(indexKey, prefix, func(ids []string) ([]string, bool) {
+		idx := slices.Index(ids, id)
+		if idx < 0 {
+			return ids, false
+		}
+		return slices.Delete(ids, idx, idx+1), true
+	})
+}
+
+// forEachTicket calls fn for every stored ticket. Tickets that fail to load
+// are logged and skipped.
+func (p *Plugin) forEachTicket(fn func(ticket *Ticket)) error {
+	ids, _, err := p.getKeyIndex(ticketIndexKey, ticketKeyPrefix)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		ticket, err := p.getTicket(id)
+		if err != nil {
+			p.API.LogError("Failed to load ticket", "error", err.Error(), "ticket_id", id)
+			continue
+		}
+		if ticket != nil {
+			fn(ticket)
+		}
+	}
+
+	return nil
+}
+
+// TicketFilter selects tickets for listing. Empty fields match everything.
+// Status also accepts "active" for tickets that are not done and "done" for
+// tickets that are.
+type TicketFilter struct {
+	ChannelID   string
+	Status      string
+	TeamName    string
+	ProjectName string
+	Environment string
+	Priority    string
+	Type        string
+	AssigneeID  string
+	ReporterID  string
+}
+
+// Matches reports whether the ticket satisfies the filter
+func (f *TicketFilter) Matches(ticket *Ticket, workflow *Workflow) bool {
+	switch {
+	case f.ChannelID != "" && ticket.ChannelID != f.ChannelID:
+		return false
+	case f.TeamName != "" && !strings.EqualFold(ticket.TeamName, f.TeamName):
+		return false
+	case f.ProjectName != "" && !strings.EqualFold(ticket.ProjectName, f.ProjectName):
+		return false
+	case f.Environment != "" && !strings.EqualFold(ticket.Environment, f.Environment):
+		return false
+	case f.Priority != "" && !strings.EqualFold(ticket.Priority, f.Priority):
+		return false
+	case f.Type != "" && !strings.EqualFold(ticket.Type, f.Type):
+		return false
+	case f.AssigneeID != "" && ticket.AssigneeID != f.AssigneeID:
+		return false
+	case f.ReporterID != "" && ticket.ReporterID != f.ReporterID:
+		return false
+	}
+
+	switch strings.ToLower(f.Status) {
+	case "":
+		return true
+	case "active":
+		return !workflow.IsDone(ticket.Status)
+	case "done":
+		return workflow.IsDone(ticket.Status)
+	default:
+		return strings.EqualFold(ticket.Status, f.Status)
+	}
+}
+
+// listTickets returns one page of the tickets matching the filter, newest
+// first, along with the total number of matches
+func (p *Plugin) listTickets(filter *TicketFilter, page, perPage int) ([]*Ticket, int, error) {
+	var matches []*Ticket
+	err := p.forEachTicket(func(ticket *Ticket) {
+		if filter.Matches(ticket, p.getWorkflow(ticket.Type)) {
+			matches = append(matches, ticket)
+		}
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	sortTicketsNewestFirst(matches)
+
+	start := page * perPage
+	if start >= len(matches) {
+		return nil, len(matches), nil
+	}
+	end := start + perPage
+	if end > len(matches) {
+		end = len(matches)
+	}
+	return matches[start:end], len(matches), nil
+}
+
+// sortTicketsNewestFirst orders tickets by creation time, newest first
+func sortTicketsNewestFirst(tickets []*Ticket) {
+	sort.Slice(tickets, func(i, j int) bool {
+		return tickets[i].CreatedAt > tickets[j].CreatedAt
+	})
+}
+
+// nextTicketNumber atomically reserves the next ticket number for the prefix.
+// Channels sharing a prefix share a sequence so ticket numbers stay unique.
+func (p *Plugin) nextTicketNumber(prefix string) (int64, error) {
+	key := ticketSeqKey(prefix)
+	for i := 0; i < maxSequenceAttempts; i++ {
+		current, appErr := p.API.KVGet(key)
+		if appErr != nil {
+			return 0, errors.Wrap(appErr, "failed to get ticket sequence")
+		}
+
+		var last int64
+		if current != nil {
+			parsed, err := strconv.ParseInt(string(current), 10, 64)
+			if err != nil {
+				return 0, errors.Wrap(err, "failed to parse ticket sequence")
+			}
+			last = parsed
+		}
+
+		next := last + 1
+		ok, appErr := p.API.KVCompareAndSet(key, current, []byte(strconv.FormatInt(next, 10)))
+		if appErr != nil {
+			return 0, errors.Wrap(appErr, "failed to update ticket sequence")
+		}
+		if ok {
+			return next, nil
+		}
+	}
+
+	return 0, errors.New("failed to reserve ticket number: too much contention")
+}
+
+// parseTicketKey splits a ticket number such as TCK-142 into its prefix and number
+func parseTicketKey(ref string) (string, int64, bool) {
+	idx := strings.LastIndex(ref, "-")
+	if idx <= 0 || idx == len(ref)-1 {
+		return "", 0, false
+	}
+
+	number, err := strconv.ParseInt(ref[idx+1:], 10, 64)
+	if err != nil || number <= 0 {
+		return "", 0, false
+	}
+	return strings.ToUpper(ref[:idx]), number, true
+}
+
+// findTicket resolves a user supplied reference to a ticket. It accepts a
+// ticket number (TCK-142 or #TCK-142), a ticket post ID or a ticket ID and
+// returns nil if nothing matches. Legacy ticket posts are imported.
+func (p *Plugin) findTicket(ref string) (*Ticket, error) {
+	ref = strings.TrimPrefix(strings.TrimSpace(ref), "#")
+	if ref == "" {
+		return nil, nil
+	}
+
+	if prefix, number, ok := parseTicketKey(ref); ok {
+		ticketID, appErr := p.API.KVGet(ticketRefKey(fmt.Sprintf("%s-%d", prefix, number)))
+		if appErr != nil {
+			return nil, errors.Wrap(appErr, "failed to get ticket number index")
+		}
+		if ticketID == nil {
+			return nil, nil
+		}
+		return p.getTicket(string(ticketID))
+	}
+
+	if !model.IsValidId(ref) {
+		return nil, nil
+	}
+
+	ticket, err := p.getTicketByPostID(ref)
+	if err != nil || ticket != nil {
+		return ticket, err
+	}
+	ticket, err = p.getTicket(ref)
+	if err != nil || ticket != nil {
+		return ticket, err
+	}
+
+	// Ticket posts from before ticket records are imported on first use
+	post, appErr := p.API.GetPost(ref)
+	if appErr != nil {
+		return nil, nil
+	}
+	return p.importLegacyTicket(post)
+}
+
+// ensureActivationTime stores the current time under the key unless it is
+// already set, and returns the stored time in Unix milliseconds. It records
+// when a version that changed the stored data was first activated.
+func (p *Plugin) ensureActivationTime(key string) (int64, error) {
+	now := []byte(strconv.FormatInt(model.GetMillis(), 10))
+	if _, appErr := p.API.KVSetWithOptions(key, now, model.PluginKVSetOptions{Atomic: true, OldValue: nil}); appErr != nil {
+		return 0, errors.Wrapf(appErr, "failed to store %s", key)
+	}
+
+	stored, appErr := p.API.KVGet(key)
+	if appErr != nil {
+		return 0, errors.Wrapf(appErr, "failed to load %s", key)
+	}
+	value, err := strconv.ParseInt(string(stored), 10, 64)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to parse %s", key)
+	}
+	return value, nil
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+// Message templates that admins can override
+const (
+	templateTicketPost = "post"
+	templateResolved   = "resolved"
+	templateReopened   = "reopened"
+	templateAttachment = "attachment"
+)
+
+// templateNames lists the message templates in the order they are documented
+var templateNames = []string{templateTicketPost, templateResolved, templateReopened, templateAttachment}
+
+// defaultTemplateText holds the built-in message templates
+var defaultTemplateText = map[string]string{
+	templateTicketPost: "🎫 **New Ticket Created**\n\n" +
+		"**Ticket Details:**\n\n" +
+		"• Ticket: **{{.Key}}**\n" +
+		"{{if .TypeName}}• Type: **{{.TypeName}}**\n{{end}}" +
+		"• Summary: **{{default \"No summary provided\" .Ticket.Summary}}**\n" +
+		"• Reporter: **{{mention .Ticket.ReporterID}}**\n" +
+		"{{range .Fields}}• {{.Label}}: **{{.Value}}**\n{{end}}" +
+		"{{if .CanResolve}}\n💡 **To mark as resolved:** Use `/resolve {{.Key}}`{{end}}" +
+		"{{range .Ticket.Mentions}} @{{.}}{{end}}",
+	templateResolved:   "✅ Resolved by {{mention .ActorID}}",
+	templateReopened:   "🔄 Reopened by {{mention .ActorID}}",
+	templateAttachment: "Click below to update this ticket",
+}
+
+// defaultTemplates are the parsed built-in templates
+var defaultTemplates = func() map[string]*template.Template {
+	templates := make(map[string]*template.Template, len(defaultTemplateText))
+	for name, text := range defaultTemplateText {
+		templates[name] = template.Must(parseMessageTemplate(name, text))
+	}
+	return templates
+}()
+
+// ticketTemplateData is the data available to message templates
+type ticketTemplateData struct {
+	Ticket      *Ticket
+	Key         string
+	TypeName    string
+	Status      string
+	StatusEmoji string
+	Assignee    string
+	Fields      []renderedField
+	CanResolve  bool
+
+	// ActorID, From and To are set for the resolved and reopened replies
+	ActorID string
+	From    string
+	To      string
+}
+
+// templateFuncs returns the helpers available to message templates. With a
+// nil plugin the helpers that need the server return placeholders, which is
+// used to validate templates when the configuration is saved.
+func templateFuncs(p *Plugin) template.FuncMap {
+	username := func(userID string) string {
+		if p == nil || userID == "" {
+			return userID
+		}
+		return p.getUsername(userID)
+	}
+
+	return template.FuncMap{
+		"username": username,
+		"mention": func(userID string) string {
+			if userID == "" {
+				return ""
+			}
+			return "@" + username(userID)
+		},
+		"displayName": func(userID string) string {
+			if p == nil || userID == "" {
+				return userID
+			}
+			user, appErr := p.API.GetUser(userID)
+			if appErr != nil {
+				return userID
+			}
+			return user.GetDisplayName(model.ShowNicknameFullName)
+		},
+		"relativeTime": func(millis int64) string {
+			if millis == 0 {
+				return ""
+			}
+			delta := model.GetMillis() - millis
+			if delta >= 0 {
+				return formatDuration(delta) + " ago"
+			}
+			return "in " + formatDuration(-delta)
+		},
+		"formatTime": func(millis int64) string {
+			if millis == 0 {
+				return ""
+			}
+			return time.UnixMilli(millis).UTC().Format("2006-01-02 15:04 MST")
+		},
+		"default": func(fallback, value string) string {
+			if value == "" {
+				return fallback
+			}
+			return value
+		},
+		"upper": strings.ToUpper,
+		"lower": strings.ToLower,
+		"join":  strings.Join,
+	}
+}
+
+// parseMessageTemplate parses a message template with the template helpers
+func parseMessageTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Funcs(templateFuncs(nil)).Option("missingkey=error").Parse(text)
+}
+
+// parseMessageTemplates parses the configured templates, falling back to the
+// built-in template for every empty setting. Each template is executed
+// against a sample ticket so mistakes such as unknown fields are reported
+// when the settings are saved rather than when a ticket is created.
+func parseMessageTemplates(texts map[string]string) (map[string]*template.Template, error) {
+	templates := make(map[string]*template.Template, len(defaultTemplates))
+	for name, tmpl := range defaultTemplates {
+		templates[name] = tmpl
+	}
+
+	for _, name := range templateNames {
+		setting, text := templateSettingName(name), texts[name]
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		tmpl, err := parseMessageTemplate(name, text)
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s is not a valid template", setting)
+		}
+		if _, err := executeMessageTemplate(tmpl, templateFuncs(nil), sampleTemplateData()); err != nil {
+			return nil, errors.Wrapf(err, "%s cannot be rendered", setting)
+		}
+		templates[name] = tmpl
+	}
+	return templates, nil
+}
+
+// templateSettingName returns the plugin setting holding the template
+func templateSettingName(name string) string {
+	switch name {
+	case templateTicketPost:
+		return "TicketPostTemplate"
+	case templateResolved:
+		return "ResolvedReplyTemplate"
+	case templateReopened:
+		return "ReopenedReplyTemplate"
+	default:
+		return "AttachmentTextTemplate"
+	}
+}
+
+// executeMessageTemplate renders the template with the given helpers. The
+// template is cloned so concurrent renders never share helper state.
+func executeMessageTemplate(tmpl *template.Template, funcs template.FuncMap, data *ticketTemplateData) (string, error) {
+	clone, err := tmpl.Clone()
+	if err != nil {
+		return "", err
+	}
+
+	var b bytes.Buffer
+	if err := clone.Funcs(funcs).Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// renderMessage renders the named template for the ticket. A template that
+// fails at runtime is logged and the built-in template is used instead.
+func (p *Plugin) renderMessage(name string, data *ticketTemplateData) string {
+	tmpl := p.getConfiguration().templates[name]
+	if tmpl == nil {
+		tmpl = defaultTemplates[name]
+	}
+
+	funcs := templateFuncs(p)
+	message, err := executeMessageTemplate(tmpl, funcs, data)
+	if err == nil {
+		return message
+	}
+
+	p.API.LogError("Failed to render message template, using the default", "error", err.Error(), "template", name, "ticket", data.Key)
+	message, err = executeMessageTemplate(defaultTemplates[name], funcs, data)
+	if err != nil {
+		p.API.LogError("Failed to render default message template", "error", err.Error(), "template", name)
+		return data.Key
+	}
+	return message
+}
+
+// ticketTemplateData collects the template data for the ticket's current state
+func (p *Plugin) ticketTemplateData(ticket *Ticket) *ticketTemplateData {
+	workflow := p.getWorkflow(ticket.Type)
+
+	assignee := "Unassigned"
+	if ticket.AssigneeID != "" {
+		assignee = "@" + p.getUsername(ticket.AssigneeID)
+	}
+
+	data := &ticketTemplateData{
+		Ticket:     ticket,
+		Key:        ticket.Key(),
+		TypeName:   p.ticketTypeName(ticket.Type),
+		Status:     workflow.StatusName(ticket.Status),
+		Assignee:   assignee,
+		Fields:     p.renderCustomFields(ticket),
+		CanResolve: workflow.CanTransition(ticket.Status, TicketStatusResolved),
+	}
+	if s := workflow.Status(ticket.Status); s != nil {
+		data.StatusEmoji = s.Emoji
+	}
+	return data
+}
+
+// sampleTemplateData returns a representative ticket used to validate and
+// preview templates
+func sampleTemplateData() *ticketTemplateData {
+	now := model.GetMillis()
+	ticket := &Ticket{
+		ID:          "sample",
+		Prefix:      defaultTicketPrefix,
+		Number:      142,
+		Type:        "bug",
+		ReporterID:  "reporter",
+		TeamName:    "devops",
+		ProjectName: "backend",
+		Environment: "production",
+		Priority:    "urgent",
+		Summary:     "Checkout fails with a 500 error",
+		Description: "Customers cannot complete checkout since the last deploy.",
+		Fields:      map[string]string{"customer_id": "C-1001"},
+		Mentions:    []string{"jack", "sara"},
+		Status:      TicketStatusOpen,
+		AssigneeID:  "assignee",
+		CreatedAt:   now - time.Hour.Milliseconds(),
+		UpdatedAt:   now,
+	}
+
+	return &ticketTemplateData{
+		Ticket:     ticket,
+		Key:        ticket.Key(),
+		TypeName:   "Bug",
+		Status:     "Open",
+		Assignee:   "@assignee",
+		Fields:     []renderedField{{Name: "customer_id", Label: "Customer ID", Value: "C-1001"}},
+		CanResolve: true,
+		ActorID:    "actor",
+		From:       "Open",
+		To:         "Resolved",
+	}
+}
+
+// handlePreviewCommand handles `/ticket preview [template] [ticket]` and
+// shows the rendered template for a ticket, or for a sample ticket
+func (p *Plugin) handlePreviewCommand(args *model.CommandArgs, params []string) *model.CommandResponse {
+	name := templateTicketPost
+	if len(params) > 0 {
+		name = strings.ToLower(params[0])
+	}
+	if _, ok := defaultTemplates[name]; !ok {
+		return ephemeralResponse(fmt.Sprintf("Unknown template `%s`. Use one of: `%s`.\n\nUsage: /ticket preview [template] [ticket]", name, strings.Join(templateNames, "`, `")))
+	}
+
+	data := sampleTemplateData()
+	source := "a sample ticket"
+	if len(params) > 1 {
+		ticket, err := p.findTicket(params[1])
+		if err != nil {
+			return ephemeralResponse("Failed to find ticket: " + err.Error())
+		}
+		if ticket == nil || !p.API.HasPermissionToChannel(args.UserId, ticket.ChannelID, model.PermissionReadChannel) {
+			return ephemeralResponse(fmt.Sprintf("Ticket `%s` was not found. Please use a ticket number such as TCK-142.", params[1]))
+		}
+		data = p.ticketTemplateData(ticket)
+		data.ActorID, data.From, data.To = args.UserId, data.Status, data.Status
+		source = ticket.Key()
+	} else {
+		// Helpers such as mention need real users to render sensibly
+		data.Ticket.ReporterID, data.Ticket.AssigneeID, data.ActorID = args.UserId, args.UserId, args.UserId
+		data.Assignee = "@" + p.getUsername(args.UserId)
+	}
+
+	return ephemeralResponse(fmt.Sprintf("Preview of the `%s` template (%s) for %s:\n\n---\n\n%s",
+		name, templateSettingName(name), source, p.renderMessage(name, data)))
+}
@@ -2,9 +2,11 @@ package main
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
 )
 
 // buildPostPriority constructs a PostPriority object from a plain string value.
@@ -19,138 +21,602 @@ func buildPostPriority(priority string) *model.PostPriority {
 	}
 }
 
-// createTicket creates a new ticket post with the provided data
-func (p *Plugin) createTicket(ticketData TicketDialog, channelId, userId string) error {
-	ticketMentions := p.getTicketMentionUsers(ticketData.TeamName, channelId)
+// createTicket creates a new ticket record and its post from the provided data
+func (p *Plugin) createTicket(ticketData TicketDialog, channelId, userId, source string) (*Ticket, error) {
+	p.applyTicketDefaults(&ticketData, channelId)
+	priority := ticketData.Priority
 
-	priority := "standard"
-	if ticketData.Priority != "" {
-		priority = ticketData.Priority
+	ticketType := p.getTicketType(ticketData.Type)
+	mentions := p.getTicketMentionUsers(ticketData.TeamName, channelId)
+	if ticketType != nil {
+		ticketData.Type = ticketType.ID
+		mentions = append(mentions, ticketType.Mentions...)
 	}
 
-	summary := "No summary provided"
-	if ticketData.Summary != "" {
-		summary = ticketData.Summary
+	prefix := p.getTicketPrefix(channelId)
+	number, err := p.nextTicketNumber(prefix)
+	if err != nil {
+		p.API.LogError("Failed to reserve ticket number", "error", err.Error(), "prefix", prefix)
+		return nil, err
 	}
 
-	// Create ticket post
+	now := model.GetMillis()
+	ticket := &Ticket{
+		ID:          model.NewId(),
+		Prefix:      prefix,
+		Number:      number,
+		Type:        ticketData.Type,
+		ChannelID:   channelId,
+		ReporterID:  userId,
+		TeamName:    ticketData.TeamName,
+		ProjectName: ticketData.ProjectName,
+		Environment: ticketData.Environment,
+		Priority:    priority,
+		Summary:     ticketData.Summary,
+		Description: ticketData.Description,
+		Fields:      withCustomFieldDefaults(p.getCustomFields(ticketData.Type), ticketData.Fields),
+		Mentions:    mentions,
+		Status:      TicketStatusOpen,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if target, ok := p.getSLATarget(priority, ticket.Environment); ok {
+		ticket.SLA = newTicketSLA(target, now)
+	}
+
+	// The ticket post and its thread are posted by the bot; the reporter is
+	// named in the content
 	ticketPost := &model.Post{
 		ChannelId: channelId,
-		UserId:    userId,
-		Message: fmt.Sprintf("🎫 **New Ticket Created**\n\n"+
-			"**Ticket Details:**\n\n"+
-			"• Team: **%s**\n"+
-			"• Project: **%s**\n"+
-			"• Environment: **%s**\n"+
-			"• Summary: **%s**\n\n\n"+
-			"**Status:** Open\n\n"+
-			"💡 **To mark as resolved:** Use `/resolve %s`",
-			ticketData.TeamName,
-			ticketData.ProjectName,
-			ticketData.Environment,
-			summary,
-			"placeholder"),
-		Type: model.PostTypeDefault,
-	}
-
-	ticketPost.Metadata = &model.PostMetadata{Priority: buildPostPriority(priority)}
-
-	for _, member := range ticketMentions {
-		ticketPost.Message += fmt.Sprintf(" @%s", member)
-	}
-
-	// Create the post
-	firstPost, err := p.API.CreatePost(ticketPost)
-	if err != nil {
-		p.API.LogError("Failed to create ticket post", "error", err.Error())
-		return err
+		UserId:    p.botUserID,
+		Type:      model.PostTypeDefault,
 	}
+	p.renderTicketPost(ticketPost, ticket)
 
-	updatePost := firstPost.Clone()
-	updatePost.Message = strings.Replace(updatePost.Message, "placeholder", firstPost.Id, 1)
-	if updatePost.Metadata == nil {
-		updatePost.Metadata = &model.PostMetadata{}
+	firstPost, appErr := p.API.CreatePost(ticketPost)
+	if appErr != nil {
+		p.API.LogError("Failed to create ticket post", "error", appErr.Error())
+		return nil, appErr
 	}
-	updatePost.Metadata.Priority = buildPostPriority(priority)
-	p.attachResolveButton(updatePost, firstPost.Id, firstPost.ChannelId)
 
-	if _, err := p.API.UpdatePost(updatePost); err != nil {
-		p.API.LogError("Failed to update post with button", "error", err.Error())
-		return err
+	ticket.PostID = firstPost.Id
+	if err := p.saveTicket(ticket); err != nil {
+		p.API.LogError("Failed to save ticket", "error", err.Error(), "post_id", firstPost.Id)
+		// A ticket post without a record cannot be used, so remove it
+		if appErr := p.API.DeletePost(firstPost.Id); appErr != nil {
+			p.API.LogError("Failed to delete ticket post", "error", appErr.Error(), "post_id", firstPost.Id)
+		}
+		return nil, err
 	}
+	p.trackTicketSLA(ticket)
 
 	descriptionPost := &model.Post{
 		ChannelId: channelId,
-		UserId:    userId,
-		Message:   ticketData.Description,
+		UserId:    p.botUserID,
+		Message:   fmt.Sprintf("📝 **Description** from @%s\n\n%s", p.getUsername(userId), ticket.Description),
 		Type:      model.PostTypeDefault,
 		RootId:    firstPost.Id,
 	}
 
-	if _, err := p.API.CreatePost(descriptionPost); err != nil {
-		p.API.LogError("Failed to create description post", "error", err.Error())
+	// The ticket exists at this point, so a missing description reply must
+	// not fail its creation
+	if _, appErr := p.API.CreatePost(descriptionPost); appErr != nil {
+		p.API.LogError("Failed to create description post", "error", appErr.Error(), "ticket", ticket.Key())
+	}
+
+	p.recordTicketEvent(EventTicketCreated, ticket, userId, source, creationChanges(ticket))
+
+	return ticket, nil
+}
+
+// validateTicketFields checks that the ticket data is complete and uses the
+// configured options and custom fields. It returns a user facing reason, or
+// an empty string.
+func (p *Plugin) validateTicketFields(ticketData TicketDialog, channelId string) string {
+	if reason := p.validateStandardFields(ticketData, channelId); reason != "" {
+		return reason
+	}
+
+	fields := p.getCustomFields(ticketData.Type)
+	if problems := p.validateCustomFields(fields, withCustomFieldDefaults(fields, ticketData.Fields)); len(problems) > 0 {
+		return describeFieldProblems(problems)
+	}
+	return ""
+}
+
+// validateTicketPatch checks the ticket data the patch would produce. Only
+// custom fields set by the patch are checked, so tickets created before a
+// field was added can still be updated.
+func (p *Plugin) validateTicketPatch(ticket *Ticket, patch *TicketPatch) string {
+	if reason := p.validateStandardFields(patch.dialog(ticket), ticket.ChannelID); reason != "" {
+		return reason
+	}
+
+	problems := p.validateCustomFields(p.getCustomFields(ticket.Type), patch.Fields)
+	for name := range problems {
+		if _, ok := patch.Fields[name]; !ok {
+			delete(problems, name)
+		}
+	}
+	if len(problems) > 0 {
+		return describeFieldProblems(problems)
+	}
+	return ""
+}
+
+// validateStandardFields checks the built-in ticket fields against the
+// options of the channel
+func (p *Plugin) validateStandardFields(ticketData TicketDialog, channelId string) string {
+	if ticketData.Type != "" && p.getTicketType(ticketData.Type) == nil {
+		return fmt.Sprintf("unknown ticket type %q", ticketData.Type)
+	}
+	if ticketData.TeamName == "" || ticketData.ProjectName == "" || ticketData.Environment == "" || ticketData.Description == "" {
+		return "team_name, project_name, environment and description are required"
+	}
+	if !hasOption(p.getTeamOptions(channelId), ticketData.TeamName) {
+		return fmt.Sprintf("unknown team %q", ticketData.TeamName)
+	}
+	if !hasOption(p.getProjectOptions(channelId), ticketData.ProjectName) {
+		return fmt.Sprintf("unknown project %q", ticketData.ProjectName)
+	}
+	if !hasOption(environmentOptions, ticketData.Environment) {
+		return fmt.Sprintf("unknown environment %q", ticketData.Environment)
+	}
+	if ticketData.Priority != "" && !hasOption(priorityOptions, ticketData.Priority) {
+		return fmt.Sprintf("unknown priority %q", ticketData.Priority)
+	}
+	return ""
+}
+
+// hasOption reports whether value is one of the option values
+func hasOption(options []*model.PostActionOptions, value string) bool {
+	for _, option := range options {
+		if option.Value == value {
+			return true
+		}
+	}
+	return false
+}
+
+// updateTicketFields applies the patch to the ticket, saves it, re-renders its
+// post and lists the changes in the ticket thread. SLA deadlines are
+// recomputed when the priority or environment changes.
+func (p *Plugin) updateTicketFields(ticket *Ticket, patch *TicketPatch, actorID, source string) error {
+	var changes map[string]FieldChange
+	updated, err := p.modifyTicket(ticket.ID, func(latest *Ticket) bool {
+		changes = patch.changes(latest)
+		if len(changes) == 0 {
+			return false
+		}
+
+		previousPriority, previousEnvironment := latest.Priority, latest.Environment
+		patch.apply(latest)
+
+		if latest.Priority != previousPriority || latest.Environment != previousEnvironment {
+			latest.SLA = nil
+			if target, ok := p.getSLATarget(latest.Priority, latest.Environment); ok {
+				latest.SLA = newTicketSLA(target, latest.CreatedAt)
+			}
+		}
+		latest.UpdatedAt = model.GetMillis()
+		return true
+	})
+	if err != nil || updated == nil {
+		return err
+	}
+	*ticket = *updated
+	p.trackTicketSLA(ticket)
+
+	if err := p.updateTicketPost(ticket); err != nil {
 		return err
 	}
 
+	if err := p.postTicketReply(ticket, p.describeTicketChanges(ticket, changes, actorID)); err != nil {
+		p.API.LogError("Failed to post ticket changes", "error", err.Error(), "ticket", ticket.Key())
+	}
+
+	p.recordTicketEvent(EventTicketUpdated, ticket, actorID, source, changes)
+
 	return nil
 }
 
-// attachResolveButton adds a resolve button to the post
-func (p *Plugin) attachResolveButton(post *model.Post, postID, channelID string) {
-	integrationURL := fmt.Sprintf("/plugins/%s/api/v1/runresolve", pluginID)
+// ticketChangeLabels are the display names of the built-in ticket fields, in
+// the order changes are listed
+var ticketChangeLabels = []struct{ field, label string }{
+	{"team_name", "team"},
+	{"project_name", "project"},
+	{"environment", "environment"},
+	{"priority", "priority"},
+	{"summary", "summary"},
+	{"description", "description"},
+}
+
+// describeTicketChanges formats the changes of an edit for the ticket thread,
+// e.g. "priority **standard** → **urgent**". The description is only
+// reported as changed since it is usually too long to repeat.
+func (p *Plugin) describeTicketChanges(ticket *Ticket, changes map[string]FieldChange, actorID string) string {
+	value := func(v string) string {
+		if v == "" {
+			return "_empty_"
+		}
+		return "**" + v + "**"
+	}
 
-	attachment := &model.SlackAttachment{
-		Text:     "Click below to resolve this ticket",
-		Fallback: "Resolve Ticket",
-		Color:    "#e40504",
-		Actions: []*model.PostAction{
-			{
-				Id:   "runresolve",
-				Type: model.PostActionTypeButton,
-				Name: "Resolve Ticket",
-				Integration: &model.PostActionIntegration{
-					URL: integrationURL,
-					Context: map[string]interface{}{
-						"post_id":    postID,
-						"channel_id": channelID,
-					},
-				},
-			},
+	var lines []string
+	for _, entry := range ticketChangeLabels {
+		change, ok := changes[entry.field]
+		switch {
+		case !ok:
+		case entry.field == "description":
+			lines = append(lines, "• changed description")
+		default:
+			lines = append(lines, fmt.Sprintf("• changed %s %s → %s", entry.label, value(change.From), value(change.To)))
+		}
+	}
+
+	fields := make(map[string]*CustomField)
+	for _, field := range p.getCustomFields(ticket.Type) {
+		fields[field.Name] = field
+	}
+	var custom []string
+	for key, change := range changes {
+		name := strings.TrimPrefix(key, "fields.")
+		if name == key {
+			continue
+		}
+		field := fields[name]
+		if field == nil {
+			custom = append(custom, fmt.Sprintf("• changed %s %s → %s", name, value(change.From), value(change.To)))
+			continue
+		}
+		from, to := change.From, change.To
+		if from != "" {
+			from = p.formatCustomFieldValue(field, from)
+		}
+		if to != "" {
+			to = p.formatCustomFieldValue(field, to)
+		}
+		custom = append(custom, fmt.Sprintf("• changed %s %s → %s", field.DisplayName, value(from), value(to)))
+	}
+	sort.Strings(custom)
+
+	return fmt.Sprintf("✏️ @%s edited %s:\n%s", p.getUsername(actorID), ticket.Key(), strings.Join(append(lines, custom...), "\n"))
+}
+
+// openEditTicketDialog opens the ticket dialog prefilled with the ticket's
+// current values. The submission is handled by handleEditSubmit.
+func (p *Plugin) openEditTicketDialog(triggerID string, ticket *Ticket) error {
+	values := TicketDialog{
+		TeamName:    ticket.TeamName,
+		ProjectName: ticket.ProjectName,
+		Environment: ticket.Environment,
+		Priority:    ticket.Priority,
+		Description: ticket.Description,
+		Summary:     ticket.Summary,
+		Type:        ticket.Type,
+		Fields:      ticket.Fields,
+	}
+
+	dialog := model.OpenDialogRequest{
+		TriggerId: triggerID,
+		URL:       fmt.Sprintf("/plugins/%s/api/v1/edit/submit", pluginID),
+		Dialog: model.Dialog{
+			Title:       "Edit " + ticket.Key(),
+			Elements:    p.ticketDialogElements(ticket.ChannelID, values),
+			SubmitLabel: "Save Changes",
+			State:       ticket.Key(),
 		},
 	}
 
-	if post.Props == nil {
-		post.Props = make(model.StringInterface)
+	if appErr := p.API.OpenInteractiveDialog(dialog); appErr != nil {
+		return errors.Wrap(appErr, "failed to open edit dialog")
 	}
-	post.Props["attachments"] = []*model.SlackAttachment{attachment}
+	return nil
 }
 
-// attachReopenButton adds a reopen button to the post
-func (p *Plugin) attachReopenButton(post *model.Post, postID, channelID string) {
-	integrationURL := fmt.Sprintf("/plugins/%s/api/v1/runreopen", pluginID)
+// renderTicketPost writes the message, details, priority and action buttons
+// for the ticket's current state onto the post, using the configured
+// templates. The ticket identity is kept in the post props so the post can
+// be matched to its ticket without reading the message.
+func (p *Plugin) renderTicketPost(post *model.Post, ticket *Ticket) {
+	workflow := p.getWorkflow(ticket.Type)
+	data := p.ticketTemplateData(ticket)
+	post.Message = p.renderMessage(templateTicketPost, data)
+
+	if post.Metadata == nil {
+		post.Metadata = &model.PostMetadata{}
+	}
+	post.Metadata.Priority = buildPostPriority(ticket.Priority)
 
 	attachment := &model.SlackAttachment{
-		Text:     "Click below to reopen this ticket",
-		Fallback: "Reopen Ticket",
-		Color:    "#0ddb21",
-		Actions: []*model.PostAction{
-			{
-				Id:   "runreopen",
+		Text:     p.renderMessage(templateAttachment, data),
+		Fallback: "Update Ticket",
+		Fields:   ticketAttachmentFields(data),
+		Actions:  p.ticketActions(ticket, workflow),
+	}
+
+	attachment.Color = "#e40504"
+	if workflow.IsDone(ticket.Status) {
+		attachment.Color = "#0ddb21"
+	}
+
+	post.AddProp(ticketIDProp, ticket.ID)
+	post.AddProp(ticketKeyProp, ticket.Key())
+	post.AddProp("attachments", []*model.SlackAttachment{attachment})
+}
+
+// ticketAttachmentFields returns the ticket details shown as attachment fields
+func ticketAttachmentFields(data *ticketTemplateData) []*model.SlackAttachmentField {
+	status := data.Status
+	if data.StatusEmoji != "" {
+		status = data.StatusEmoji + " " + status
+	}
+
+	return []*model.SlackAttachmentField{
+		{Title: "Team", Value: data.Ticket.TeamName, Short: true},
+		{Title: "Project", Value: data.Ticket.ProjectName, Short: true},
+		{Title: "Environment", Value: data.Ticket.Environment, Short: true},
+		{Title: "Status", Value: status, Short: true},
+		{Title: "Assignee", Value: data.Assignee, Short: true},
+	}
+}
+
+// transitionTicket validates and records a status change on the ticket,
+// re-renders its post and announces the change in the ticket thread
+func (p *Plugin) transitionTicket(ticket *Ticket, to, actorID, source string) error {
+	workflow := p.getWorkflow(ticket.Type)
+	if !workflow.CanTransition(ticket.Status, to) {
+		return errors.Errorf("cannot move ticket from %s to %s", workflow.StatusName(ticket.Status), workflow.StatusName(to))
+	}
+
+	from := ticket.Status
+	now := model.GetMillis()
+	updated, err := p.modifyTicket(ticket.ID, func(latest *Ticket) bool {
+		// The caller's checks were made against the status it loaded
+		if latest.Status != from {
+			return false
+		}
+		latest.Status = to
+		latest.UpdatedAt = now
+		if latest.AcknowledgedAt == 0 {
+			latest.AcknowledgedAt = now
+		}
+		if workflow.IsDone(to) {
+			if !workflow.IsDone(from) {
+				latest.ResolvedAt = now
+				latest.ResolvedBy = actorID
+			}
+		} else {
+			latest.ResolvedAt = 0
+			latest.ResolvedBy = ""
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if updated == nil {
+		return errTicketChanged
+	}
+	*ticket = *updated
+	p.trackTicketSLA(ticket)
+
+	if err := p.updateTicketPost(ticket); err != nil {
+		return err
+	}
+
+	event := EventTicketStatusChanged
+	switch {
+	case workflow.IsDone(to) && !workflow.IsDone(from):
+		event = EventTicketResolved
+	case !workflow.IsDone(to) && workflow.IsDone(from):
+		event = EventTicketReopened
+	}
+	p.recordTicketEvent(event, ticket, actorID, source, map[string]FieldChange{"status": {From: from, To: to}})
+
+	var message string
+	switch {
+	case to == TicketStatusResolved, to == TicketStatusOpen && workflow.IsDone(from):
+		name := templateResolved
+		if to == TicketStatusOpen {
+			name = templateReopened
+		}
+		data := p.ticketTemplateData(ticket)
+		data.ActorID, data.From, data.To = actorID, workflow.StatusName(from), workflow.StatusName(to)
+		message = p.renderMessage(name, data)
+	default:
+		message = fmt.Sprintf("🔁 @%s changed the status: **%s** → **%s**", p.getUsername(actorID), workflow.StatusName(from), workflow.StatusName(to))
+	}
+
+	return p.postTicketReply(ticket, message)
+}
+
+// assignTicket records a new assignee on the ticket, re-renders its post and
+// announces the change in the ticket thread. Tickets in a done status cannot
+// be assigned.
+func (p *Plugin) assignTicket(ticket *Ticket, assigneeID, actorID, source string) error {
+	workflow := p.getWorkflow(ticket.Type)
+	previousID := ticket.AssigneeID
+	now := model.GetMillis()
+	done := false
+	updated, err := p.modifyTicket(ticket.ID, func(latest *Ticket) bool {
+		// The ticket may have been resolved since the caller loaded it
+		if workflow.IsDone(latest.Status) {
+			done = true
+			return false
+		}
+		// A concurrent claim must not be silently replaced
+		if latest.AssigneeID != previousID {
+			return false
+		}
+		latest.AssigneeID = assigneeID
+		latest.AssignedAt = now
+		latest.UpdatedAt = now
+		if latest.AcknowledgedAt == 0 {
+			latest.AcknowledgedAt = now
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if done {
+		return errTicketDone
+	}
+	if updated == nil {
+		return errTicketChanged
+	}
+	*ticket = *updated
+
+	if err := p.updateTicketPost(ticket); err != nil {
+		return err
+	}
+
+	p.recordTicketEvent(EventTicketAssigned, ticket, actorID, source, map[string]FieldChange{"assignee_id": {From: previousID, To: assigneeID}})
+
+	var message string
+	switch {
+	case assigneeID == actorID && previousID == "":
+		message = fmt.Sprintf("🙋 @%s claimed this ticket", p.getUsername(actorID))
+	case previousID == "":
+		message = fmt.Sprintf("👤 Assigned to @%s by @%s", p.getUsername(assigneeID), p.getUsername(actorID))
+	default:
+		message = fmt.Sprintf("👤 Reassigned from @%s to @%s by @%s", p.getUsername(previousID), p.getUsername(assigneeID), p.getUsername(actorID))
+	}
+
+	return p.postTicketReply(ticket, message)
+}
+
+// checkAssignee returns a user facing reason why the user cannot be assigned
+// the ticket, or an empty string if the assignment is allowed
+func (p *Plugin) checkAssignee(ticket *Ticket, userID string) string {
+	if _, appErr := p.API.GetChannelMember(ticket.ChannelID, userID); appErr != nil {
+		return fmt.Sprintf("@%s is not a member of the ticket's channel.", p.getUsername(userID))
+	}
+	if ticket.AssigneeID == userID {
+		return fmt.Sprintf("Ticket %s is already assigned to @%s.", ticket.Key(), p.getUsername(userID))
+	}
+	return ""
+}
+
+// updateTicketPost re-renders the ticket post from the stored record
+func (p *Plugin) updateTicketPost(ticket *Ticket) error {
+	post, appErr := p.API.GetPost(ticket.PostID)
+	if appErr != nil {
+		return errors.Wrap(appErr, "failed to get ticket post")
+	}
+
+	updatePost := post.Clone()
+	p.renderTicketPost(updatePost, ticket)
+	if _, appErr := p.API.UpdatePost(updatePost); appErr != nil {
+		return errors.Wrap(appErr, "failed to update ticket post")
+	}
+
+	return nil
+}
+
+// postTicketReply adds a message from the bot to the ticket thread. Messages
+// about user actions name the user in the text.
+func (p *Plugin) postTicketReply(ticket *Ticket, message string) error {
+	replyPost := &model.Post{
+		ChannelId: ticket.ChannelID,
+		UserId:    p.botUserID,
+		Message:   message,
+		Type:      model.PostTypeDefault,
+		RootId:    ticket.PostID,
+	}
+
+	if _, appErr := p.API.CreatePost(replyPost); appErr != nil {
+		return errors.Wrap(appErr, "failed to create ticket reply")
+	}
+
+	return nil
+}
+
+// ticketActions returns one button per status the ticket can move to, the
+// Edit button, and the Claim and Reassign buttons while the ticket is not done
+func (p *Plugin) ticketActions(ticket *Ticket, workflow *Workflow) []*model.PostAction {
+	integrationURL := fmt.Sprintf("/plugins/%s/api/v1/transition", pluginID)
+
+	var actions []*model.PostAction
+	for _, next := range workflow.NextStatuses(ticket.Status) {
+		actions = append(actions, &model.PostAction{
+			Id:   actionID("transition", next.ID),
+			Type: model.PostActionTypeButton,
+			Name: next.ActionLabel(),
+			Integration: &model.PostActionIntegration{
+				URL:     integrationURL,
+				Context: p.ticketActionContext(ticket, "to", next.ID),
+			},
+		})
+	}
+
+	actions = append(actions, &model.PostAction{
+		Id:   "edit",
+		Type: model.PostActionTypeButton,
+		Name: "Edit",
+		Integration: &model.PostActionIntegration{
+			URL:     fmt.Sprintf("/plugins/%s/api/v1/edit", pluginID),
+			Context: p.ticketActionContext(ticket),
+		},
+	})
+
+	if !workflow.IsDone(ticket.Status) {
+		if ticket.AssigneeID == "" {
+			actions = append(actions, &model.PostAction{
+				Id:   "claim",
 				Type: model.PostActionTypeButton,
-				Name: "Reopen Ticket",
+				Name: "Claim",
 				Integration: &model.PostActionIntegration{
-					URL: integrationURL,
-					Context: map[string]interface{}{
-						"post_id":    postID,
-						"channel_id": channelID,
-					},
+					URL:     fmt.Sprintf("/plugins/%s/api/v1/claim", pluginID),
+					Context: p.ticketActionContext(ticket),
 				},
+			})
+		}
+		actions = append(actions, &model.PostAction{
+			Id:   "reassign",
+			Type: model.PostActionTypeButton,
+			Name: "Reassign",
+			Integration: &model.PostActionIntegration{
+				URL:     fmt.Sprintf("/plugins/%s/api/v1/reassign", pluginID),
+				Context: p.ticketActionContext(ticket),
 			},
-		},
+		})
 	}
 
-	if post.Props == nil {
-		post.Props = make(model.StringInterface)
+	return actions
+}
+
+// ticketPermalink returns the link to a ticket post
+func ticketPermalink(siteURL, teamName, postID string) string {
+	return fmt.Sprintf("%s/%s/pl/%s", strings.TrimSuffix(siteURL, "/"), teamName, postID)
+}
+
+// escapeTableCell makes a value safe to render inside a Markdown table cell
+func escapeTableCell(value string) string {
+	value = strings.ReplaceAll(value, "\n", " ")
+	return strings.ReplaceAll(value, "|", "\\|")
+}
+
+// truncate shortens a value to at most limit runes, adding an ellipsis when cut
+func truncate(value string, limit int) string {
+	runes := []rune(value)
+	if len(runes) <= limit {
+		return value
+	}
+	return string(runes[:limit-1]) + "…"
+}
+
+// actionID builds a post action ID. Mattermost only routes action IDs made of
+// letters and digits, so everything else is stripped.
+func actionID(parts ...string) string {
+	var b strings.Builder
+	for _, part := range parts {
+		for _, r := range part {
+			if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+				b.WriteRune(r)
+			}
+		}
 	}
-	post.Props["attachments"] = []*model.SlackAttachment{attachment}
+	return b.String()
 }
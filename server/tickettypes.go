@@ -0,0 +1,141 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ticketTypeIDPattern restricts ticket type IDs to short command friendly words
+var ticketTypeIDPattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,29}$`)
+
+// ticketSubcommands are the /ticket subcommands, which ticket types may not shadow
+var ticketSubcommands = []string{"assign", "audit", "edit", "list", "preview", "help"}
+
+// TicketType is an admin defined kind of ticket, e.g. a bug or an access
+// request, created with `/ticket <id>`. Its fields are added after the global
+// custom fields and its workflow replaces the global one.
+type TicketType struct {
+	ID              string         `json:"id"`
+	Name            string         `json:"name"`
+	Description     string         `json:"description"`
+	Title           string         `json:"title"`
+	Fields          []*CustomField `json:"fields"`
+	DefaultPriority string         `json:"default_priority"`
+	Mentions        []string       `json:"mentions"`
+	Workflow        *Workflow      `json:"workflow"`
+}
+
+// validate checks the type definition. globalFields are the custom fields
+// shared by all types, which type fields may not redefine.
+func (t *TicketType) validate(globalFields []*CustomField) error {
+	if !ticketTypeIDPattern.MatchString(t.ID) {
+		return errors.Errorf("type id %q must be lower-case letters, digits, dashes and underscores", t.ID)
+	}
+	for _, subcommand := range ticketSubcommands {
+		if t.ID == subcommand {
+			return errors.Errorf("type id %q is reserved for /ticket %s", t.ID, subcommand)
+		}
+	}
+	if t.Name == "" {
+		return errors.Errorf("type %q needs a name", t.ID)
+	}
+	if t.DefaultPriority != "" && !hasOption(priorityOptions, t.DefaultPriority) {
+		return errors.Errorf("type %q has unknown default_priority %q", t.ID, t.DefaultPriority)
+	}
+
+	seen := make(map[string]bool)
+	for _, field := range globalFields {
+		seen[field.Name] = true
+	}
+	var fields []*CustomField
+	for _, field := range t.Fields {
+		if field == nil {
+			continue
+		}
+		if err := field.validate(); err != nil {
+			return errors.Wrapf(err, "type %q", t.ID)
+		}
+		if seen[field.Name] {
+			return errors.Errorf("type %q: duplicate field %q", t.ID, field.Name)
+		}
+		seen[field.Name] = true
+		fields = append(fields, field)
+	}
+	t.Fields = fields
+
+	for i, username := range t.Mentions {
+		t.Mentions[i] = strings.TrimPrefix(strings.TrimSpace(username), "@")
+	}
+
+	if t.Workflow != nil {
+		if err := t.Workflow.validate(); err != nil {
+			return errors.Wrapf(err, "type %q workflow", t.ID)
+		}
+	}
+	return nil
+}
+
+// dialogTitle returns the title of the type's create dialog
+func (t *TicketType) dialogTitle() string {
+	if t.Title != "" {
+		return t.Title
+	}
+	return "Create New " + t.Name
+}
+
+// parseTicketTypes validates the `TicketTypesConfig` setting
+func parseTicketTypes(raw string, globalFields []*CustomField) ([]*TicketType, error) {
+	var types []*TicketType
+	if err := parseJSONSetting("TicketTypesConfig", raw, &types); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var valid []*TicketType
+	for _, ticketType := range types {
+		if ticketType == nil {
+			continue
+		}
+		if err := ticketType.validate(globalFields); err != nil {
+			return nil, errors.Wrap(err, "TicketTypesConfig")
+		}
+		if seen[ticketType.ID] {
+			return nil, errors.Errorf("TicketTypesConfig: duplicate type %q", ticketType.ID)
+		}
+		seen[ticketType.ID] = true
+		valid = append(valid, ticketType)
+	}
+	return valid, nil
+}
+
+// getTicketTypes returns the configured ticket types
+func (p *Plugin) getTicketTypes() []*TicketType {
+	return p.getConfiguration().ticketTypes
+}
+
+// getTicketType returns the ticket type with the given ID, or nil
+func (p *Plugin) getTicketType(typeID string) *TicketType {
+	if typeID == "" {
+		return nil
+	}
+	for _, ticketType := range p.getTicketTypes() {
+		if strings.EqualFold(ticketType.ID, typeID) {
+			return ticketType
+		}
+	}
+	return nil
+}
+
+// ticketTypeName returns the display name of the ticket type, or an empty
+// string for untyped tickets. Types removed from the configuration show their ID.
+func (p *Plugin) ticketTypeName(typeID string) string {
+	if typeID == "" {
+		return ""
+	}
+	if ticketType := p.getTicketType(typeID); ticketType != nil {
+		return ticketType.Name
+	}
+	return typeID
+}
@@ -1,11 +1,176 @@
 package main
 
+import "fmt"
+
 // TicketDialog represents the dialog data for ticket creation
 type TicketDialog struct {
-	TeamName    string `json:"team_name"`
-	ProjectName string `json:"project_name"`
-	Environment string `json:"environment"`
-	Priority    string `json:"priority"`
-	Description string `json:"description"`
-	Summary     string `json:"summary,omitempty"`
+	TeamName    string            `json:"team_name"`
+	ProjectName string            `json:"project_name"`
+	Environment string            `json:"environment"`
+	Priority    string            `json:"priority"`
+	Description string            `json:"description"`
+	Summary     string            `json:"summary,omitempty"`
+	Type        string            `json:"type,omitempty"`
+	Fields      map[string]string `json:"fields,omitempty"`
+}
+
+// TicketPatch holds the ticket fields to change. Nil fields are left untouched.
+type TicketPatch struct {
+	TeamName    *string `json:"team_name"`
+	ProjectName *string `json:"project_name"`
+	Environment *string `json:"environment"`
+	Priority    *string `json:"priority"`
+	Summary     *string `json:"summary"`
+	Description *string `json:"description"`
+
+	// Fields sets the given custom fields; an empty value clears the field
+	Fields map[string]string `json:"fields"`
+}
+
+// FieldChange records the previous and new value of a changed ticket field
+type FieldChange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// changes returns the fields the patch would change on the ticket
+func (p *TicketPatch) changes(ticket *Ticket) map[string]FieldChange {
+	patched := *ticket
+	p.apply(&patched)
+
+	changes := make(map[string]FieldChange)
+	add := func(field, from, to string) {
+		if from != to {
+			changes[field] = FieldChange{From: from, To: to}
+		}
+	}
+	add("team_name", ticket.TeamName, patched.TeamName)
+	add("project_name", ticket.ProjectName, patched.ProjectName)
+	add("environment", ticket.Environment, patched.Environment)
+	add("priority", ticket.Priority, patched.Priority)
+	add("summary", ticket.Summary, patched.Summary)
+	add("description", ticket.Description, patched.Description)
+	for name := range p.Fields {
+		add("fields."+name, ticket.Fields[name], patched.Fields[name])
+	}
+	return changes
+}
+
+// apply copies the set fields of the patch onto the ticket
+func (p *TicketPatch) apply(ticket *Ticket) {
+	if p.TeamName != nil {
+		ticket.TeamName = *p.TeamName
+	}
+	if p.ProjectName != nil {
+		ticket.ProjectName = *p.ProjectName
+	}
+	if p.Environment != nil {
+		ticket.Environment = *p.Environment
+	}
+	if p.Priority != nil {
+		ticket.Priority = *p.Priority
+	}
+	if p.Summary != nil {
+		ticket.Summary = *p.Summary
+	}
+	if p.Description != nil {
+		ticket.Description = *p.Description
+	}
+	if len(p.Fields) > 0 {
+		// Copy so a patched copy of the ticket never shares its map with the original
+		fields := make(map[string]string, len(ticket.Fields)+len(p.Fields))
+		for name, value := range ticket.Fields {
+			fields[name] = value
+		}
+		for name, value := range p.Fields {
+			if value == "" {
+				delete(fields, name)
+			} else {
+				fields[name] = value
+			}
+		}
+		ticket.Fields = fields
+	}
+}
+
+// dialog returns the ticket data the ticket would have once the patch is applied
+func (p *TicketPatch) dialog(ticket *Ticket) TicketDialog {
+	patched := *ticket
+	p.apply(&patched)
+	return TicketDialog{
+		TeamName:    patched.TeamName,
+		ProjectName: patched.ProjectName,
+		Environment: patched.Environment,
+		Priority:    patched.Priority,
+		Description: patched.Description,
+		Summary:     patched.Summary,
+		Type:        patched.Type,
+		Fields:      patched.Fields,
+	}
+}
+
+// Ticket statuses
+const (
+	TicketStatusOpen     = "open"
+	TicketStatusResolved = "resolved"
+)
+
+// defaultTicketPrefix is used for ticket numbers in channels without a configured prefix
+const defaultTicketPrefix = "TCK"
+
+// Post props identifying the ticket rendered in a ticket post
+const (
+	ticketIDProp  = "ticket_id"
+	ticketKeyProp = "ticket_key"
+)
+
+// Ticket is the structured record persisted in the KV store for every ticket.
+// The ticket post is rendered from this record, never the other way around.
+type Ticket struct {
+	ID             string            `json:"id"`
+	Prefix         string            `json:"prefix"`
+	Number         int64             `json:"number"`
+	Type           string            `json:"type,omitempty"`
+	PostID         string            `json:"post_id"`
+	ChannelID      string            `json:"channel_id"`
+	ReporterID     string            `json:"reporter_id"`
+	TeamName       string            `json:"team_name"`
+	ProjectName    string            `json:"project_name"`
+	Environment    string            `json:"environment"`
+	Priority       string            `json:"priority"`
+	Summary        string            `json:"summary,omitempty"`
+	Description    string            `json:"description"`
+	Fields         map[string]string `json:"fields,omitempty"`
+	Mentions       []string          `json:"mentions,omitempty"`
+	Status         string            `json:"status"`
+	AssigneeID     string            `json:"assignee_id,omitempty"`
+	AssignedAt     int64             `json:"assigned_at,omitempty"`
+	AcknowledgedAt int64             `json:"acknowledged_at,omitempty"`
+	SLA            *TicketSLA        `json:"sla,omitempty"`
+	CreatedAt      int64             `json:"created_at"`
+	UpdatedAt      int64             `json:"updated_at"`
+	ResolvedAt     int64             `json:"resolved_at,omitempty"`
+	ResolvedBy     string            `json:"resolved_by,omitempty"`
+
+	// Thread activity, recorded from replies by people in the ticket thread
+	LastActivityAt   int64    `json:"last_activity_at,omitempty"`
+	ReplyCount       int      `json:"reply_count,omitempty"`
+	FirstResponseAt  int64    `json:"first_response_at,omitempty"`
+	FirstResponderID string   `json:"first_responder_id,omitempty"`
+	Participants     []string `json:"participants,omitempty"`
+}
+
+// TicketSLA tracks the SLA deadlines of a ticket and which notifications were sent
+type TicketSLA struct {
+	ResponseDueAt      int64 `json:"response_due_at,omitempty"`
+	ResolutionDueAt    int64 `json:"resolution_due_at,omitempty"`
+	ResponseWarned     bool  `json:"response_warned,omitempty"`
+	ResponseBreached   bool  `json:"response_breached,omitempty"`
+	ResolutionWarned   bool  `json:"resolution_warned,omitempty"`
+	ResolutionBreached bool  `json:"resolution_breached,omitempty"`
+}
+
+// Key returns the human-readable ticket number, e.g. TCK-142
+func (t *Ticket) Key() string {
+	return fmt.Sprintf("%s-%d", t.Prefix, t.Number)
 }
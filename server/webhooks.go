@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+// Ticket lifecycle events delivered to webhook subscriptions
+const (
+	EventTicketCreated       = "ticket.created"
+	EventTicketUpdated       = "ticket.updated"
+	EventTicketAssigned      = "ticket.assigned"
+	EventTicketStatusChanged = "ticket.status_changed"
+	EventTicketResolved      = "ticket.resolved"
+	EventTicketReopened      = "ticket.reopened"
+)
+
+const (
+	// webhookDeliveryKeyPrefix prefixes queued webhook deliveries in the KV store
+	webhookDeliveryKeyPrefix = "webhookdelivery_"
+
+	// webhookQueueKey lists the IDs of queued deliveries, so the delivery job
+	// does not walk the whole KV store
+	webhookQueueKey = "webhookqueue"
+
+	// webhookQueueInterval is how often the delivery job drains the queue
+	webhookQueueInterval = 10 * time.Second
+
+	// webhookTimeout bounds a single delivery attempt
+	webhookTimeout = 10 * time.Second
+
+	// webhookMaxAttempts is the number of attempts before a delivery is dropped
+	webhookMaxAttempts = 8
+
+	// webhookBaseBackoff is the delay before the first retry; it doubles on every failure
+	webhookBaseBackoff = 30 * time.Second
+
+	// webhookMaxBackoff caps the delay between retries
+	webhookMaxBackoff = time.Hour
+)
+
+// WebhookSubscription is an admin configured endpoint receiving ticket events.
+// Subscriptions without an ID get one derived from their settings.
+type WebhookSubscription struct {
+	ID     string   `json:"id"`
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Secret string   `json:"secret"`
+}
+
+// defaultID derives a stable ID from the subscription's settings, so that
+// subscriptions sharing a URL are told apart
+func (s *WebhookSubscription) defaultID() string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%s", s.URL, strings.Join(s.Events, ","), s.Secret)
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// wants reports whether the subscription receives the event. Subscriptions
+// without events receive everything.
+func (s *WebhookSubscription) wants(event string) bool {
+	if len(s.Events) == 0 {
+		return true
+	}
+	for _, e := range s.Events {
+		if e == event || e == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookPayload is the JSON body posted to subscriptions
+type WebhookPayload struct {
+	ID        string                 `json:"id"`
+	Event     string                 `json:"event"`
+	Timestamp int64                  `json:"timestamp"`
+	ActorID   string                 `json:"actor_id,omitempty"`
+	Changes   map[string]FieldChange `json:"changes,omitempty"`
+	Ticket    *Ticket                `json:"ticket"`
+}
+
+// webhookDelivery is a queued payload for one subscription. Deliveries queued
+// by earlier versions carry only the URL.
+type webhookDelivery struct {
+	ID             string          `json:"id"`
+	SubscriptionID string          `json:"subscription_id"`
+	URL            string          `json:"url"`
+	Event          string          `json:"event"`
+	Body           json.RawMessage `json:"body"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  int64           `json:"next_attempt_at"`
+	LastError      string          `json:"last_error,omitempty"`
+}
+
+func webhookDeliveryKey(id string) string {
+	return webhookDeliveryKeyPrefix + id
+}
+
+// emitTicketEvent queues the event for every subscription that wants it.
+// Failures are logged so ticket actions never fail because of webhooks.
+func (p *Plugin) emitTicketEvent(event string, ticket *Ticket, actorID string, changes map[string]FieldChange) {
+	subscriptions := p.getWebhookSubscriptions()
+	if len(subscriptions) == 0 {
+		return
+	}
+
+	payload := &WebhookPayload{
+		ID:        model.NewId(),
+		Event:     event,
+		Timestamp: model.GetMillis(),
+		ActorID:   actorID,
+		Changes:   changes,
+		Ticket:    ticket,
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		p.API.LogError("Failed to marshal webhook payload", "error", err.Error(), "event", event)
+		return
+	}
+
+	for _, subscription := range subscriptions {
+		if !subscription.wants(event) {
+			continue
+		}
+
+		delivery := &webhookDelivery{
+			ID:             model.NewId(),
+			SubscriptionID: subscription.ID,
+			URL:            subscription.URL,
+			Event:          event,
+			Body:           body,
+			NextAttemptAt:  payload.Timestamp,
+		}
+		if err := p.saveWebhookDelivery(delivery); err != nil {
+			p.API.LogError("Failed to queue webhook delivery", "error", err.Error(), "event", event, "url", subscription.URL)
+		}
+	}
+}
+
+// processWebhookQueue is run by the cluster job and attempts every due delivery
+func (p *Plugin) processWebhookQueue() {
+	subscriptions := make(map[string]*WebhookSubscription)
+	byURL := make(map[string][]*WebhookSubscription)
+	for _, subscription := range p.getWebhookSubscriptions() {
+		subscriptions[subscription.ID] = subscription
+		byURL[subscription.URL] = append(byURL[subscription.URL], subscription)
+	}
+
+	ids, _, err := p.getKeyIndex(webhookQueueKey, webhookDeliveryKeyPrefix)
+	if err != nil {
+		p.API.LogError("Failed to list webhook deliveries", "error", err.Error())
+		return
+	}
+
+	now := model.GetMillis()
+	for _, id := range ids {
+		delivery, err := p.getWebhookDelivery(webhookDeliveryKey(id))
+		if err != nil {
+			p.API.LogError("Failed to load webhook delivery", "error", err.Error(), "id", id)
+			continue
+		}
+		if delivery == nil {
+			// The delivery was removed but not its queue entry
+			if err := p.removeFromKeyIndex(webhookQueueKey, webhookDeliveryKeyPrefix, id); err != nil {
+				p.API.LogError("Failed to remove webhook delivery from the queue", "error", err.Error(), "id", id)
+			}
+			continue
+		}
+		if delivery.NextAttemptAt > now {
+			continue
+		}
+
+		// Deliveries for removed subscriptions are dropped; secrets are always
+		// read from the current configuration. Older deliveries are matched by
+		// URL when that is unambiguous.
+		subscription, ok := subscriptions[delivery.SubscriptionID]
+		if delivery.SubscriptionID == "" && len(byURL[delivery.URL]) == 1 {
+			subscription, ok = byURL[delivery.URL][0], true
+		}
+		if !ok {
+			p.deleteWebhookDelivery(delivery)
+			continue
+		}
+
+		err = p.sendWebhook(subscription, delivery)
+		if err == nil {
+			p.deleteWebhookDelivery(delivery)
+			continue
+		}
+
+		delivery.Attempts++
+		delivery.LastError = err.Error()
+		if delivery.Attempts >= webhookMaxAttempts {
+			p.API.LogError("Dropping webhook delivery after too many attempts", "error", err.Error(), "event", delivery.Event, "url", delivery.URL, "attempts", delivery.Attempts)
+			p.deleteWebhookDelivery(delivery)
+			continue
+		}
+
+		delivery.NextAttemptAt = now + webhookBackoff(delivery.Attempts).Milliseconds()
+		p.API.LogWarn("Webhook delivery failed, will retry", "error", err.Error(), "event", delivery.Event, "url", delivery.URL, "attempts", delivery.Attempts)
+		if err := p.saveWebhookDelivery(delivery); err != nil {
+			p.API.LogError("Failed to requeue webhook delivery", "error", err.Error(), "url", delivery.URL)
+		}
+	}
+}
+
+// sendWebhook posts the signed payload to the subscription
+func (p *Plugin) sendWebhook(subscription *WebhookSubscription, delivery *webhookDelivery) error {
+	req, err := http.NewRequest(http.MethodPost, subscription.URL, bytes.NewReader(delivery.Body))
+	if err != nil {
+		return errors.Wrap(err, "failed to build webhook request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Ticket-Event", delivery.Event)
+	req.Header.Set("X-Ticket-Delivery", delivery.ID)
+	if subscription.Secret != "" {
+		req.Header.Set("X-Ticket-Signature", "sha256="+signWebhookBody(subscription.Secret, delivery.Body))
+	}
+
+	client := &http.Client{Timeout: webhookTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "webhook request failed")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// signWebhookBody returns the hex encoded HMAC-SHA256 of the body
+func signWebhookBody(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookBackoff returns the delay before the given retry attempt
+func webhookBackoff(attempts int) time.Duration {
+	backoff := webhookBaseBackoff
+	for i := 1; i < attempts && backoff < webhookMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > webhookMaxBackoff {
+		backoff = webhookMaxBackoff
+	}
+	return backoff
+}
+
+func (p *Plugin) saveWebhookDelivery(delivery *webhookDelivery) error {
+	data, err := json.Marshal(delivery)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal webhook delivery")
+	}
+	if appErr := p.API.KVSet(webhookDeliveryKey(delivery.ID), data); appErr != nil {
+		return errors.Wrap(appErr, "failed to save webhook delivery")
+	}
+	return p.addToKeyIndex(webhookQueueKey, webhookDeliveryKeyPrefix, delivery.ID)
+}
+
+func (p *Plugin) getWebhookDelivery(key string) (*webhookDelivery, error) {
+	data, appErr := p.API.KVGet(key)
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to get webhook delivery")
+	}
+	if data == nil {
+		return nil, nil
+	}
+
+	var delivery webhookDelivery
+	if err := json.Unmarshal(data, &delivery); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal webhook delivery")
+	}
+	return &delivery, nil
+}
+
+func (p *Plugin) deleteWebhookDelivery(delivery *webhookDelivery) {
+	if appErr := p.API.KVDelete(webhookDeliveryKey(delivery.ID)); appErr != nil {
+		p.API.LogError("Failed to delete webhook delivery", "error", appErr.Error(), "id", delivery.ID)
+		return
+	}
+	if err := p.removeFromKeyIndex(webhookQueueKey, webhookDeliveryKeyPrefix, delivery.ID); err != nil {
+		p.API.LogError("Failed to remove webhook delivery from the queue", "error", err.Error(), "id", delivery.ID)
+	}
+}
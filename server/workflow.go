@@ -0,0 +1,130 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// WorkflowStatus is a single state of the ticket workflow
+type WorkflowStatus struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Emoji  string `json:"emoji,omitempty"`
+	Action string `json:"action,omitempty"`
+	Done   bool   `json:"done,omitempty"`
+}
+
+// ActionLabel returns the button label used to move a ticket into this status
+func (s *WorkflowStatus) ActionLabel() string {
+	if s.Action != "" {
+		return s.Action
+	}
+	return s.Name
+}
+
+// Workflow lists the ticket statuses and the transitions allowed between them.
+// New tickets always start in the open status.
+type Workflow struct {
+	Statuses    []*WorkflowStatus   `json:"statuses"`
+	Transitions map[string][]string `json:"transitions"`
+}
+
+// defaultWorkflow mirrors the original Open/Resolved behaviour
+var defaultWorkflow = &Workflow{
+	Statuses: []*WorkflowStatus{
+		{ID: TicketStatusOpen, Name: "Open", Action: "Reopen Ticket"},
+		{ID: TicketStatusResolved, Name: "Resolved", Emoji: "✅", Action: "Resolve Ticket", Done: true},
+	},
+	Transitions: map[string][]string{
+		TicketStatusOpen:     {TicketStatusResolved},
+		TicketStatusResolved: {TicketStatusOpen},
+	},
+}
+
+// Status returns the status with the given ID, or nil if it is not part of the workflow
+func (w *Workflow) Status(id string) *WorkflowStatus {
+	for _, s := range w.Statuses {
+		if s.ID == id {
+			return s
+		}
+	}
+	return nil
+}
+
+// StatusName returns the display name of a status, falling back to its ID
+func (w *Workflow) StatusName(id string) string {
+	if s := w.Status(id); s != nil {
+		return s.Name
+	}
+	return id
+}
+
+// NextStatuses returns the statuses a ticket in the given status can move to.
+// Tickets left in a status that was removed from the workflow can only be reopened.
+func (w *Workflow) NextStatuses(from string) []*WorkflowStatus {
+	if w.Status(from) == nil {
+		return []*WorkflowStatus{w.Status(TicketStatusOpen)}
+	}
+
+	var next []*WorkflowStatus
+	for _, id := range w.Transitions[from] {
+		if s := w.Status(id); s != nil {
+			next = append(next, s)
+		}
+	}
+	return next
+}
+
+// CanTransition reports whether a ticket may move from one status to another
+func (w *Workflow) CanTransition(from, to string) bool {
+	for _, s := range w.NextStatuses(from) {
+		if s.ID == to {
+			return true
+		}
+	}
+	return false
+}
+
+// IsDone reports whether the status counts as resolved
+func (w *Workflow) IsDone(id string) bool {
+	s := w.Status(id)
+	return s != nil && s.Done
+}
+
+// validate checks that the workflow is usable by the commands and buttons
+func (w *Workflow) validate() error {
+	if len(w.Statuses) == 0 {
+		return errors.New("workflow has no statuses")
+	}
+
+	seen := make(map[string]bool)
+	for _, s := range w.Statuses {
+		if s == nil || strings.TrimSpace(s.ID) == "" || strings.TrimSpace(s.Name) == "" {
+			return errors.New("every workflow status needs an id and a name")
+		}
+		if seen[s.ID] {
+			return errors.Errorf("duplicate workflow status %q", s.ID)
+		}
+		seen[s.ID] = true
+	}
+
+	for _, required := range []string{TicketStatusOpen, TicketStatusResolved} {
+		if !seen[required] {
+			return errors.Errorf("workflow must define the %q status", required)
+		}
+	}
+
+	for from, targets := range w.Transitions {
+		if !seen[from] {
+			return errors.Errorf("transition from unknown status %q", from)
+		}
+		for _, to := range targets {
+			if !seen[to] {
+				return errors.Errorf("transition from %q to unknown status %q", from, to)
+			}
+		}
+	}
+
+	return nil
+}